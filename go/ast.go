@@ -6,54 +6,230 @@ import "strings"
 
 const DEF_VALUE = 0
 const DEF_FUNCTION = 1
+const DEF_MACRO = 2
+const DEF_HEADER = 3
+const DEF_CONST = 4
+const DEF_VAR = 5
+const DEF_TYPE = 6
+const DEF_CLASS = 7
+const DEF_GENERIC = 8
+const DEF_METHOD = 9
 
 type Def struct {
 	name string
 	typ int
-	params []string
+	params *Params
 	body AST
+	span *Span
+	header *ModuleHeader     // only for DEF_HEADER
+	macros []string          // the macros used by the definition
+	rtype *RecordType        // only for DEF_TYPE
+	class *Class             // only for DEF_CLASS
+	method *MethodDef        // only for DEF_METHOD
+}
+
+// the names bound by a definition
+
+func (d *Def) names() []string {
+	if d.typ == DEF_TYPE {
+		names, _ := d.rtype.bindings()
+		return names
+	}
+	if d.typ == DEF_METHOD {
+		return []string{}
+	}
+	return []string{d.name}
 }
 
 type AST interface {
 	eval(*Env) (Value, error)
 	evalPartial(*Env) (*PartialResult, error)
 	str() string
+	getSpan() *Span
+	walk(map[string]bool, func(AST, map[string]bool))
+}
+
+// parameters of a function:
+// required ones, then optional ones with their defaults, then possibly
+// a rest parameter bound to the list of remaining arguments
+
+type Params struct {
+	required []string
+	optional []string
+	defaults []AST
+	rest string        // "" if no rest parameter
+}
+
+func simpleParams(names []string) *Params {
+	return &Params{names, []string{}, []AST{}, ""}
+}
+
+func (ps *Params) names() []string {
+	names := append([]string{}, ps.required...)
+	names = append(names, ps.optional...)
+	if ps.rest != "" {
+		names = append(names, ps.rest)
+	}
+	return names
+}
+
+func (ps *Params) accepts(n int) bool {
+	if n < len(ps.required) {
+		return false
+	}
+	return ps.rest != "" || n <= len(ps.required) + len(ps.optional)
+}
+
+// create the environment for evaluating the body of fn on args
+// defaults are evaluated in that environment, so they can refer to
+// earlier parameters
+
+func (ps *Params) bind(fn *VFunction, args []Value) (*Env, error) {
+	if !ps.accepts(len(args)) {
+		return nil, newError(ERR_ARITY, fn, "Wrong number of arguments to application to %s", fn.str())
+	}
+	newEnv := fn.env.layer(ps.names(), nil)
+	for i, name := range ps.required {
+		newEnv.update(name, args[i])
+	}
+	n := len(ps.required)
+	for i, name := range ps.optional {
+		if n + i < len(args) {
+			newEnv.update(name, args[n + i])
+			continue
+		}
+		v, err := ps.defaults[i].eval(newEnv)
+		if err != nil {
+			return nil, err
+		}
+		newEnv.update(name, v)
+	}
+	if ps.rest != "" {
+		var rest Value = &VEmpty{}
+		for i := len(args) - 1; i >= n + len(ps.optional); i-- {
+			rest = &VCons{head: args[i], tail: rest}
+		}
+		newEnv.update(ps.rest, rest)
+	}
+	return newEnv, nil
+}
+
+func (ps *Params) str() string {
+	items := append([]string{}, ps.required...)
+	for _, name := range ps.optional {
+		items = append(items, "(" + name + ")")
+	}
+	if ps.rest != "" {
+		items = append(items, ".", ps.rest)
+	}
+	return strings.Join(items, " ")
 }
 
 type PartialResult struct {
 	exp AST
 	env *Env
 	val Value  // val is null when the result is still partial
+	frame *Frame   // set when entering the body of a function
 }
 
 type Literal struct {
 	val Value
+	span *Span
 }
 
 type Id struct {
 	name string
+	span *Span
 }
 
 type If struct {
 	cnd AST
 	thn AST
 	els AST
+	span *Span
 }
 
 type Apply struct {
 	fn   AST
 	args []AST
+	span *Span
 }
 
 type Quote struct {
 	val Value
+	span *Span
 }
 
 type LetRec struct {
 	names []string
-	params [][]string
+	params []*Params     // nil for a binding to a value
 	bodies []AST
 	body AST
+	span *Span
+}
+
+type And struct {
+	exps []AST
+	span *Span
+}
+
+type Or struct {
+	exps []AST
+	span *Span
+}
+
+type Cond struct {
+	tests []AST
+	bodies []AST
+	els AST        // nil if no else clause
+	span *Span
+}
+
+type Case struct {
+	key AST
+	values [][]Value
+	bodies []AST
+	els AST        // nil if no else clause
+	span *Span
+}
+
+type Set struct {
+	name string
+	exp AST
+	span *Span
+}
+
+type Raise struct {
+	exp AST
+	span *Span
+}
+
+type Try struct {
+	body AST
+	clauses []*Catch
+	finally AST     // nil if no finally clause
+	span *Span
+}
+
+type Catch struct {
+	kind string     // "_" for any kind, "" when pred is used
+	pred AST
+	name string
+	handler AST
+}
+
+// walk calls visit on every node of an AST along with the names
+// bound at that node
+
+func bindNames(bound map[string]bool, names []string) map[string]bool {
+	result := map[string]bool{}
+	for name := range bound {
+		result[name] = true
+	}
+	for _, name := range names {
+		result[name] = true
+	}
+	return result
 }
 
 func defaultEvalPartial(e AST, env *Env) (*PartialResult, error) {
@@ -66,23 +242,28 @@ func defaultEvalPartial(e AST, env *Env) (*PartialResult, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &PartialResult{nil, nil, v}, nil
+	return &PartialResult{nil, nil, v, nil}, nil
 }
 
 func defaultEval(e AST, env *Env) (Value, error) {
 	// evaluation with tail call optimization
+	// a tail call replaces the current frame
 	var currExp AST = e
 	currEnv := env
+	var frame *Frame
 	for {
 		partial, err := currExp.evalPartial(currEnv)
 		if err != nil {
-			return nil, err
+			return nil, traceError(err, frame)
 		}
 		if partial.val != nil {
 			return partial.val, nil
 		}
 		currExp = partial.exp
 		currEnv = partial.env
+		if partial.frame != nil {
+			frame = partial.frame
+		}
 	}
 }
 
@@ -98,8 +279,23 @@ func (e *Literal) str() string {
 	return fmt.Sprintf("Literal[%s]", e.val.str())
 }
 
+func (e *Literal) getSpan() *Span {
+	return e.span
+}
+
+func (e *Literal) walk(bound map[string]bool, visit func(AST, map[string]bool)) {
+	visit(e, bound)
+}
+
 func (e *Id) eval(env *Env) (Value, error) {
-	return env.find(e.name)
+	if isKeywordName(e.name) {
+		return intern(e.name), nil
+	}
+	v, err := env.find(e.name)
+	if err != nil {
+		return nil, locate(err, e.span)
+	}
+	return v, nil
 }
 
 func (e *Id) evalPartial(env *Env) (*PartialResult, error) {
@@ -110,6 +306,14 @@ func (e *Id) str() string {
 	return fmt.Sprintf("Id[%s]", e.name)
 }
 
+func (e *Id) getSpan() *Span {
+	return e.span
+}
+
+func (e *Id) walk(bound map[string]bool, visit func(AST, map[string]bool)) {
+	visit(e, bound)
+}
+
 func (e *If) eval(env *Env) (Value, error) {
 	return defaultEval(e, env)
 }
@@ -117,12 +321,12 @@ func (e *If) eval(env *Env) (Value, error) {
 func (e *If) evalPartial(env *Env) (*PartialResult, error) { 
 	c, err := e.cnd.eval(env)
 	if err != nil {
-		return nil, err
+		return nil, locate(err, e.span)
 	}
 	if c.isTrue() {
-		return &PartialResult{e.thn, env, nil}, nil
+		return &PartialResult{e.thn, env, nil, nil}, nil
 	} else {
-		return &PartialResult{e.els, env, nil}, nil
+		return &PartialResult{e.els, env, nil, nil}, nil
 	}
 }
 
@@ -130,34 +334,67 @@ func (e *If) str() string {
 	return fmt.Sprintf("If[%s %s %s]", e.cnd.str(), e.thn.str(), e.els.str())
 }
 
+func (e *If) getSpan() *Span {
+	return e.span
+}
+
+func (e *If) walk(bound map[string]bool, visit func(AST, map[string]bool)) {
+	visit(e, bound)
+	e.cnd.walk(bound, visit)
+	e.thn.walk(bound, visit)
+	e.els.walk(bound, visit)
+}
+
 func (e *Apply) eval(env *Env) (Value, error) {
 	return defaultEval(e, env)
 }
 
 func (e *Apply) evalPartial(env *Env) (*PartialResult, error) {
-	f, err := e.fn.eval(env)
-	if err != nil {
-		return nil, err
+	f, fnErr := e.fn.eval(env)
+	args := make([]Value, 0, len(e.args))
+	exprs := e.args
+	if id, ok := e.fn.(*Id); ok && len(exprs) > 0 && env.isMethodCandidate(id.name) {
+		// (name obj arg ...) calls method name of obj if obj has one
+		receiver, err := exprs[0].eval(env)
+		if err != nil {
+			return nil, locate(err, e.span)
+		}
+		exprs = exprs[1:]
+		if obj, ok := receiver.(*VObject); ok && obj.methods[id.name] != nil {
+			f, fnErr = obj.methods[id.name], nil
+		} else {
+			args = append(args, receiver)
+		}
+	}
+	if fnErr != nil {
+		return nil, locate(fnErr, e.span)
 	}
-	args := make([]Value, len(e.args))
-	for i := range args {
-		args[i], err = e.args[i].eval(env)
+	for _, exp := range exprs {
+		v, err := exp.eval(env)
 		if err != nil {
-			return nil, err
+			return nil, locate(err, e.span)
+		}
+		args = append(args, v)
+	}
+	if g, ok := f.(*VGeneric); ok {
+		method, err := g.dispatch(args)
+		if err != nil {
+			return nil, locate(err, e.span)
 		}
+		f = method
 	}
 	if ff, ok := f.(*VFunction); ok {
-		if len(ff.params) != len(args) {
-			return nil, fmt.Errorf("Wrong number of arguments to application to %s", ff.str())
+		newEnv, err := ff.params.bind(ff, args)
+		if err != nil {
+			return nil, locate(err, e.span)
 		}
-		newEnv := ff.env.layer(ff.params, args)
-		return &PartialResult{ff.body, newEnv, nil}, nil
+		return &PartialResult{ff.body, newEnv, nil, &Frame{ff, e.span}}, nil
 	}
 	v, err := f.apply(args)
 	if err != nil {
-		return nil, err
+		return nil, locate(err, e.span)
 	}
-	return &PartialResult{nil, nil, v}, nil
+	return &PartialResult{nil, nil, v, nil}, nil
 }
 
 func (e *Apply) str() string {
@@ -168,6 +405,18 @@ func (e *Apply) str() string {
 	return fmt.Sprintf("Apply[%s%s]", e.fn.str(), strArgs)
 }
 
+func (e *Apply) getSpan() *Span {
+	return e.span
+}
+
+func (e *Apply) walk(bound map[string]bool, visit func(AST, map[string]bool)) {
+	visit(e, bound)
+	e.fn.walk(bound, visit)
+	for _, arg := range e.args {
+		arg.walk(bound, visit)
+	}
+}
+
 func (e *Quote) eval(env *Env) (Value, error) {
 	return e.val, nil
 }
@@ -180,28 +429,411 @@ func (e *Quote) str() string {
 	return fmt.Sprintf("Quote[%s]", e.val.str())
 }
 
+func (e *Quote) getSpan() *Span {
+	return e.span
+}
+
+func (e *Quote) walk(bound map[string]bool, visit func(AST, map[string]bool)) {
+	visit(e, bound)
+}
+
 func (e *LetRec) eval(env *Env) (Value, error) {
 	return defaultEval(e, env)
 }
 
 func (e *LetRec) evalPartial(env *Env) (*PartialResult, error) {
 	if len(e.names) != len(e.params) || len(e.names) != len(e.bodies) {
-		return nil, errors.New("malformed letrec (names, params, bodies)")
+		return nil, locate(errors.New("malformed letrec (names, params, bodies)"), e.span)
 	}
 	// create the environment that we'll share across the definitions
 	// all names initially allocated #nil
 	newEnv := env.layer(e.names, nil)
 	for i, name := range e.names {
-		newEnv.update(name, &VFunction{e.params[i], e.bodies[i], newEnv})
+		if e.params[i] != nil {
+			newEnv.update(name, &VFunction{e.params[i], e.bodies[i], newEnv, name})
+		}
+	}
+	// values are evaluated in order once all functions are defined
+	for i, name := range e.names {
+		if e.params[i] == nil {
+			v, err := e.bodies[i].eval(newEnv)
+			if err != nil {
+				return nil, locate(err, e.span)
+			}
+			newEnv.update(name, v)
+		}
 	}
-	return &PartialResult{e.body, newEnv, nil}, nil
+	return &PartialResult{e.body, newEnv, nil, nil}, nil
 }
 
 func (e *LetRec) str() string {
 	bindings := make([]string, len(e.names))
 	for i := range e.names {
-		params := strings.Join(e.params[i], " ")
-		bindings[i] = fmt.Sprintf("[%s [%s] %s]", e.names[i], params, e.bodies[i].str())
+		if e.params[i] == nil {
+			bindings[i] = fmt.Sprintf("[%s %s]", e.names[i], e.bodies[i].str())
+			continue
+		}
+		bindings[i] = fmt.Sprintf("[%s [%s] %s]", e.names[i], e.params[i].str(), e.bodies[i].str())
 	}
 	return fmt.Sprintf("LetRec[%s %s]", strings.Join(bindings, " "), e.body.str())
 }
+
+func (e *LetRec) getSpan() *Span {
+	return e.span
+}
+
+func (e *LetRec) walk(bound map[string]bool, visit func(AST, map[string]bool)) {
+	visit(e, bound)
+	newBound := bindNames(bound, e.names)
+	for i, params := range e.params {
+		if params == nil {
+			e.bodies[i].walk(newBound, visit)
+			continue
+		}
+		fnBound := bindNames(newBound, params.names())
+		for _, d := range params.defaults {
+			d.walk(fnBound, visit)
+		}
+		e.bodies[i].walk(fnBound, visit)
+	}
+	e.body.walk(newBound, visit)
+}
+
+func (e *Set) eval(env *Env) (Value, error) {
+	v, err := e.exp.eval(env)
+	if err != nil {
+		return nil, locate(err, e.span)
+	}
+	if err := env.assign(e.name, v); err != nil {
+		return nil, locate(err, e.span)
+	}
+	return &VNil{}, nil
+}
+
+func (e *Set) evalPartial(env *Env) (*PartialResult, error) {
+	return defaultEvalPartial(e, env)
+}
+
+func (e *Set) str() string {
+	return fmt.Sprintf("Set[%s %s]", e.name, e.exp.str())
+}
+
+func (e *Set) getSpan() *Span {
+	return e.span
+}
+
+func (e *Set) walk(bound map[string]bool, visit func(AST, map[string]bool)) {
+	visit(e, bound)
+	e.exp.walk(bound, visit)
+}
+
+func (e *Raise) eval(env *Env) (Value, error) {
+	v, err := e.exp.eval(env)
+	if err != nil {
+		return nil, locate(err, e.span)
+	}
+	// re-raising an error keeps it as is
+	if verr, ok := v.(*VError); ok {
+		return nil, verr.err
+	}
+	msg := v.display()
+	if v.isString() {
+		msg = v.strValue()
+	}
+	return nil, locate(newError(ERR_USER, v, "%s", msg), e.span)
+}
+
+func (e *Raise) evalPartial(env *Env) (*PartialResult, error) {
+	return defaultEvalPartial(e, env)
+}
+
+func (e *Raise) str() string {
+	return fmt.Sprintf("Raise[%s]", e.exp.str())
+}
+
+func (e *Raise) getSpan() *Span {
+	return e.span
+}
+
+func (e *Raise) walk(bound map[string]bool, visit func(AST, map[string]bool)) {
+	visit(e, bound)
+	e.exp.walk(bound, visit)
+}
+
+func (e *Try) eval(env *Env) (Value, error) {
+	return defaultEval(e, env)
+}
+
+// the body is not in tail position, since we need to catch its errors
+// but without a finally clause, a handler is
+
+func (e *Try) evalPartial(env *Env) (*PartialResult, error) {
+	v, err := e.body.eval(env)
+	if err == nil {
+		if e.finally != nil {
+			if _, ferr := e.finally.eval(env); ferr != nil {
+				return nil, ferr
+			}
+		}
+		return &PartialResult{nil, nil, v, nil}, nil
+	}
+	rerr := toRuntimeError(err)
+	handler, handlerEnv, err := e.findHandler(rerr, env)
+	if err != nil {
+		return nil, err
+	}
+	if handler == nil {
+		if e.finally != nil {
+			if _, ferr := e.finally.eval(env); ferr != nil {
+				return nil, ferr
+			}
+		}
+		return nil, rerr
+	}
+	if e.finally == nil {
+		return &PartialResult{handler, handlerEnv, nil, nil}, nil
+	}
+	v, err = handler.eval(handlerEnv)
+	if _, ferr := e.finally.eval(env); ferr != nil {
+		return nil, ferr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &PartialResult{nil, nil, v, nil}, nil
+}
+
+func (e *Try) findHandler(rerr *RuntimeError, env *Env) (AST, *Env, error) {
+	value := rerr.handlerValue()
+	for _, c := range e.clauses {
+		matches := c.kind == "_" || c.kind == rerr.kind
+		if c.pred != nil {
+			pred, err := c.pred.eval(env)
+			if err != nil {
+				return nil, nil, err
+			}
+			result, err := pred.apply([]Value{value})
+			if err != nil {
+				return nil, nil, locate(err, e.span)
+			}
+			matches = result.isTrue()
+		}
+		if matches {
+			return c.handler, env.layer([]string{c.name}, []Value{value}), nil
+		}
+	}
+	return nil, nil, nil
+}
+
+func (e *Try) str() string {
+	clauses := make([]string, len(e.clauses))
+	for i, c := range e.clauses {
+		if c.pred != nil {
+			clauses[i] = fmt.Sprintf("CatchIf[%s %s %s]", c.pred.str(), c.name, c.handler.str())
+		} else {
+			clauses[i] = fmt.Sprintf("Catch[%s %s %s]", c.kind, c.name, c.handler.str())
+		}
+	}
+	finally := ""
+	if e.finally != nil {
+		finally = fmt.Sprintf(" Finally[%s]", e.finally.str())
+	}
+	return fmt.Sprintf("Try[%s %s%s]", e.body.str(), strings.Join(clauses, " "), finally)
+}
+
+func (e *Try) getSpan() *Span {
+	return e.span
+}
+
+func (e *Try) walk(bound map[string]bool, visit func(AST, map[string]bool)) {
+	visit(e, bound)
+	e.body.walk(bound, visit)
+	for _, c := range e.clauses {
+		if c.pred != nil {
+			c.pred.walk(bound, visit)
+		}
+		c.handler.walk(bindNames(bound, []string{c.name}), visit)
+	}
+	if e.finally != nil {
+		e.finally.walk(bound, visit)
+	}
+}
+
+
+func (e *And) eval(env *Env) (Value, error) {
+	return defaultEval(e, env)
+}
+
+// the last expression is in tail position
+
+func (e *And) evalPartial(env *Env) (*PartialResult, error) {
+	if len(e.exps) == 0 {
+		return &PartialResult{nil, nil, &VBoolean{true}, nil}, nil
+	}
+	for _, exp := range e.exps[:len(e.exps) - 1] {
+		v, err := exp.eval(env)
+		if err != nil {
+			return nil, locate(err, e.span)
+		}
+		if !v.isTrue() {
+			return &PartialResult{nil, nil, v, nil}, nil
+		}
+	}
+	return &PartialResult{e.exps[len(e.exps) - 1], env, nil, nil}, nil
+}
+
+func (e *And) str() string {
+	return fmt.Sprintf("And[%s]", strs(e.exps))
+}
+
+func (e *And) getSpan() *Span {
+	return e.span
+}
+
+func (e *And) walk(bound map[string]bool, visit func(AST, map[string]bool)) {
+	visit(e, bound)
+	for _, exp := range e.exps {
+		exp.walk(bound, visit)
+	}
+}
+
+func (e *Or) eval(env *Env) (Value, error) {
+	return defaultEval(e, env)
+}
+
+func (e *Or) evalPartial(env *Env) (*PartialResult, error) {
+	if len(e.exps) == 0 {
+		return &PartialResult{nil, nil, &VBoolean{false}, nil}, nil
+	}
+	for _, exp := range e.exps[:len(e.exps) - 1] {
+		v, err := exp.eval(env)
+		if err != nil {
+			return nil, locate(err, e.span)
+		}
+		if v.isTrue() {
+			return &PartialResult{nil, nil, v, nil}, nil
+		}
+	}
+	return &PartialResult{e.exps[len(e.exps) - 1], env, nil, nil}, nil
+}
+
+func (e *Or) str() string {
+	return fmt.Sprintf("Or[%s]", strs(e.exps))
+}
+
+func (e *Or) getSpan() *Span {
+	return e.span
+}
+
+func (e *Or) walk(bound map[string]bool, visit func(AST, map[string]bool)) {
+	visit(e, bound)
+	for _, exp := range e.exps {
+		exp.walk(bound, visit)
+	}
+}
+
+func (e *Cond) eval(env *Env) (Value, error) {
+	return defaultEval(e, env)
+}
+
+func (e *Cond) evalPartial(env *Env) (*PartialResult, error) {
+	for i, test := range e.tests {
+		v, err := test.eval(env)
+		if err != nil {
+			return nil, locate(err, e.span)
+		}
+		if v.isTrue() {
+			return &PartialResult{e.bodies[i], env, nil, nil}, nil
+		}
+	}
+	if e.els == nil {
+		return &PartialResult{nil, nil, &VNil{}, nil}, nil
+	}
+	return &PartialResult{e.els, env, nil, nil}, nil
+}
+
+func (e *Cond) str() string {
+	clauses := make([]string, len(e.tests))
+	for i := range e.tests {
+		clauses[i] = fmt.Sprintf("[%s %s]", e.tests[i].str(), e.bodies[i].str())
+	}
+	if e.els != nil {
+		clauses = append(clauses, fmt.Sprintf("[else %s]", e.els.str()))
+	}
+	return fmt.Sprintf("Cond[%s]", strings.Join(clauses, " "))
+}
+
+func (e *Cond) getSpan() *Span {
+	return e.span
+}
+
+func (e *Cond) walk(bound map[string]bool, visit func(AST, map[string]bool)) {
+	visit(e, bound)
+	for i, test := range e.tests {
+		test.walk(bound, visit)
+		e.bodies[i].walk(bound, visit)
+	}
+	if e.els != nil {
+		e.els.walk(bound, visit)
+	}
+}
+
+func (e *Case) eval(env *Env) (Value, error) {
+	return defaultEval(e, env)
+}
+
+func (e *Case) evalPartial(env *Env) (*PartialResult, error) {
+	key, err := e.key.eval(env)
+	if err != nil {
+		return nil, locate(err, e.span)
+	}
+	for i, values := range e.values {
+		for _, v := range values {
+			if key.isEqual(v) {
+				return &PartialResult{e.bodies[i], env, nil, nil}, nil
+			}
+		}
+	}
+	if e.els == nil {
+		return &PartialResult{nil, nil, &VNil{}, nil}, nil
+	}
+	return &PartialResult{e.els, env, nil, nil}, nil
+}
+
+func (e *Case) str() string {
+	clauses := make([]string, len(e.values))
+	for i, values := range e.values {
+		vs := make([]string, len(values))
+		for j, v := range values {
+			vs[j] = v.str()
+		}
+		clauses[i] = fmt.Sprintf("[[%s] %s]", strings.Join(vs, " "), e.bodies[i].str())
+	}
+	if e.els != nil {
+		clauses = append(clauses, fmt.Sprintf("[else %s]", e.els.str()))
+	}
+	return fmt.Sprintf("Case[%s %s]", e.key.str(), strings.Join(clauses, " "))
+}
+
+func (e *Case) getSpan() *Span {
+	return e.span
+}
+
+func (e *Case) walk(bound map[string]bool, visit func(AST, map[string]bool)) {
+	visit(e, bound)
+	e.key.walk(bound, visit)
+	for _, body := range e.bodies {
+		body.walk(bound, visit)
+	}
+	if e.els != nil {
+		e.els.walk(bound, visit)
+	}
+}
+
+func strs(exps []AST) string {
+	items := make([]string, len(exps))
+	for i, exp := range exps {
+		items[i] = exp.str()
+	}
+	return strings.Join(items, " ")
+}
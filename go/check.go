@@ -0,0 +1,159 @@
+package main
+
+import "fmt"
+import "sort"
+
+// Re-checking definitions
+//
+// When a definition changes, the definitions using it may break: a
+// macro may expand differently, a header may stop importing a name, or
+// a function may take a different number of arguments. So we re-parse
+// the definitions using it, and look for unbound identifiers and calls
+// to functions with the wrong number of arguments.
+
+// the problems found in the definitions using ref
+
+func (eco *Ecosystem) checkDependents(ref Ref) []error {
+	users := []Ref{}
+	if ref.name == kw_MODULE_HEADER {
+		// everything in a module depends on its header
+		for user := range eco.index.entries {
+			if user.module == ref.module {
+				users = append(users, user)
+			}
+		}
+		sortRefs(users)
+	} else {
+		users = append(eco.index.references(ref), eco.index.dangling(ref)...)
+	}
+	problems := []error{}
+	for _, user := range users {
+		if user != ref {
+			problems = append(problems, eco.checkDef(user)...)
+		}
+	}
+	return problems
+}
+
+func (eco *Ecosystem) checkDef(ref Ref) []error {
+	entry := eco.index.entries[ref]
+	d, err := eco.reparse(ref, entry)
+	if err != nil {
+		return []error{fmt.Errorf("%s - %w", ref.str(), err)}
+	}
+	problems := []error{}
+	walkDef(d, func(node AST, bound map[string]bool) {
+		switch e := node.(type) {
+		case *Id:
+			if bound[e.name] || isKeywordName(e.name) || eco.index.isMethod(e.name) {
+				return
+			}
+			if _, err := entry.env.find(e.name); err != nil {
+				problems = append(problems, locate(fmt.Errorf("%s - %w", ref.str(), err), e.span))
+			}
+		case *Set:
+			if bound[e.name] {
+				return
+			}
+			if _, err := entry.env.find(e.name); err != nil {
+				problems = append(problems, locate(fmt.Errorf("%s - %w", ref.str(), err), e.span))
+			}
+		case *Apply:
+			id, ok := e.fn.(*Id)
+			if !ok || bound[id.name] {
+				return
+			}
+			v, err := entry.env.find(id.name)
+			if err != nil {
+				return
+			}
+			fn, ok := v.(*VFunction)
+			if ok && !fn.params.accepts(len(e.args)) {
+				problems = append(problems, locate(fmt.Errorf("%s - wrong number of arguments (%d) to %s", ref.str(), len(e.args), id.name), e.span))
+			}
+		}
+	})
+	return problems
+}
+
+// parse a definition again from its saved source if there is one, so
+// that macros get expanded anew
+
+func (eco *Ecosystem) reparse(ref Ref, entry *IndexEntry) (*Def, error) {
+	if !eco.isPersistent(ref.module) {
+		if entry.source == "" {
+			return entry.def, nil
+		}
+		return parseSource("", entry.source, entry.env)
+	}
+	uid, err := eco.storage.getUid(ref.module, ref.name)
+	if err != nil || uid == "" {
+		return entry.def, err
+	}
+	return parseEntry(eco.storage, uid, entry.env)
+}
+
+// what a definition may change, so that it can be undone when it
+// breaks the definitions using it
+
+type DefSnapshot struct {
+	bindings map[string]Value
+	kinds map[string]int
+	entry *IndexEntry
+	header *ModuleHeader
+}
+
+func (eco *Ecosystem) snapshot(env *Env, name string) *DefSnapshot {
+	bindings := map[string]Value{}
+	for n, v := range env.bindings {
+		bindings[n] = v
+	}
+	kinds := map[string]int{}
+	for n, k := range env.kinds {
+		kinds[n] = k
+	}
+	return &DefSnapshot{bindings, kinds, eco.index.entries[Ref{env.module, name}], eco.headers[env.module]}
+}
+
+// the names whose binding changed since the snapshot, including the
+// ones that went away
+
+func (eco *Ecosystem) changedNames(env *Env, snap *DefSnapshot) []string {
+	names := []string{}
+	for n, v := range snap.bindings {
+		if current, ok := env.bindings[n]; !ok || current != v {
+			names = append(names, n)
+		}
+	}
+	for n := range env.bindings {
+		if _, ok := snap.bindings[n]; !ok {
+			names = append(names, n)
+		}
+	}
+	if eco.headers[env.module] != snap.header {
+		names = append(names, kw_MODULE_HEADER)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (eco *Ecosystem) restore(env *Env, name string, snap *DefSnapshot) {
+	for n := range env.bindings {
+		delete(env.bindings, n)
+	}
+	for n, v := range snap.bindings {
+		env.bindings[n] = v
+	}
+	env.kinds = snap.kinds
+	ref := Ref{env.module, name}
+	if snap.entry != nil {
+		eco.index.entries[ref] = snap.entry
+	} else {
+		delete(eco.index.entries, ref)
+	}
+	if snap.header != nil {
+		eco.headers[env.module] = snap.header
+	} else {
+		delete(eco.headers, env.module)
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import "fmt"
+import "strings"
+
+// Classes and objects
+//
+// (class point (ix iy)
+//   (field x ix)
+//   (field y iy)
+//   (def (move dx dy)
+//     (field-set this x (+ (field-get this x) dx))
+//     (field-set this y (+ (field-get this y) dy))))
+//
+// The class name is bound to a constructor taking the class parameters.
+// Fields are initialized in order with the parameters bound, and each
+// field is a reference cell. Methods are closures over the parameters
+// and this, the object itself.
+//
+// Application (f obj arg ...) calls the method f of obj if obj is an
+// object with such a method, and otherwise calls f as usual. This only
+// happens when f is unbound or bound at the top level of a module, so
+// that a local binding of f always wins.
+
+const thisName = "this"
+
+// whether a call to name may be a method call
+
+func (env *Env) isMethodCandidate(name string) bool {
+	eco := env.ecosystem
+	for current := env; current != nil; current = current.previous {
+		if _, ok := current.bindings[name]; ok {
+			return eco == nil || current == eco.modulesEnv[current.module] || current == eco.activesEnv[current.module]
+		}
+	}
+	return true
+}
+
+type Class struct {
+	name string
+	params *Params
+	fields []string
+	inits []AST
+	methods []string
+	methodParams []*Params
+	methodBodies []AST
+}
+
+func (c *Class) str() string {
+	items := []string{}
+	for i, field := range c.fields {
+		items = append(items, fmt.Sprintf("[field %s %s]", field, c.inits[i].str()))
+	}
+	for i, method := range c.methods {
+		items = append(items, fmt.Sprintf("[def %s [%s] %s]", method, c.methodParams[i].str(), c.methodBodies[i].str()))
+	}
+	return fmt.Sprintf("Class[%s [%s] %s]", c.name, c.params.str(), strings.Join(items, " "))
+}
+
+func (c *Class) constructor(env *Env) Value {
+	return &VPrimitive{c.name, func(args []Value) (Value, error) {
+		return c.instantiate(env, args)
+	}}
+}
+
+func (c *Class) instantiate(env *Env, args []Value) (Value, error) {
+	if !c.params.accepts(len(args)) {
+		return nil, newError(ERR_ARITY, intern(c.name), "Wrong number of arguments to constructor %s", c.name)
+	}
+	initEnv, err := c.params.bind(&VFunction{c.params, nil, env, c.name}, args)
+	if err != nil {
+		return nil, err
+	}
+	obj := &VObject{c, map[string]Value{}, map[string]Value{}}
+	for i, field := range c.fields {
+		v, err := c.inits[i].eval(initEnv)
+		if err != nil {
+			return nil, err
+		}
+		obj.fields[field] = &VReference{v}
+	}
+	objEnv := initEnv.layer([]string{thisName}, []Value{obj})
+	for i, method := range c.methods {
+		obj.methods[method] = &VFunction{c.methodParams[i], c.methodBodies[i], objEnv, c.name + "." + method}
+	}
+	return obj, nil
+}
+
+func checkObject(name string, v Value) (*VObject, error) {
+	obj, ok := v.(*VObject)
+	if !ok {
+		return nil, newError(ERR_TYPE, v, "%s - wrong argument type %s", name, v.typ())
+	}
+	return obj, nil
+}
+
+func lookupField(name string, v Value, field Value) (Value, error) {
+	obj, err := checkObject(name, v)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkArgType(name, field, isSymbol); err != nil {
+		return nil, err
+	}
+	ref, ok := obj.fields[field.strValue()]
+	if !ok {
+		return nil, newError(ERR_UNBOUND, field, "%s - %s has no field %s", name, obj.class.name, field.strValue())
+	}
+	return ref, nil
+}
+
+func lookupMethod(name string, v Value, method Value) (Value, error) {
+	obj, err := checkObject(name, v)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkArgType(name, method, isSymbol); err != nil {
+		return nil, err
+	}
+	fn, ok := obj.methods[method.strValue()]
+	if !ok {
+		return nil, newError(ERR_UNBOUND, method, "%s - %s has no method %s", name, obj.class.name, method.strValue())
+	}
+	return fn, nil
+}
+
+// field-get and field-set are compiled into applications of these
+
+var fieldGet = &VPrimitive{kw_FIELD_GET, mkPrimitive(PrimitiveDesc{kw_FIELD_GET, 2, 2,
+	func(name string, args []Value) (Value, error) {
+		ref, err := lookupField(name, args[0], args[1])
+		if err != nil {
+			return nil, err
+		}
+		return ref.getValue(), nil
+	},
+})}
+
+var fieldSet = &VPrimitive{kw_FIELD_SET, mkPrimitive(PrimitiveDesc{kw_FIELD_SET, 3, 3,
+	func(name string, args []Value) (Value, error) {
+		ref, err := lookupField(name, args[0], args[1])
+		if err != nil {
+			return nil, err
+		}
+		ref.setValue(args[2])
+		return &VNil{}, nil
+	},
+})}
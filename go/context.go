@@ -12,5 +12,6 @@ type Context struct {
 	currentModule string
 	nextCurrentModule string     // to switch modules, set nextCurrentModule != nil
 	ecosystem *Ecosystem
+	lastError *RuntimeError
 }
 	
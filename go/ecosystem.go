@@ -1,16 +1,22 @@
 package main
 
 import "fmt"
+import "sort"
+import "strings"
+import "unicode"
 
 // An ecosystem is a global set of environments associated with "modules"
 
 type Ecosystem struct {
 	modulesEnv map[string]*Env
 	activesEnv map[string]*Env
+	storage *Storage       // nil if definitions are not persisted
+	headers map[string]*ModuleHeader
+	index *Index
 }
 
 func mkEcosystem() *Ecosystem {
-	return &Ecosystem{map[string]*Env{}, map[string]*Env{}}
+	return &Ecosystem{map[string]*Env{}, map[string]*Env{}, nil, map[string]*ModuleHeader{}, mkIndex()}
 }
 
 func (eco *Ecosystem) get(name string) (*Env, error) {
@@ -22,8 +28,352 @@ func (eco *Ecosystem) get(name string) (*Env, error) {
 }
 
 func (eco *Ecosystem) mkEnv(name string, bindings map[string]Value) *Env {
-	env := &Env{bindings: bindings, previous: nil, ecosystem: eco}
+	env := &Env{bindings: bindings, previous: nil, ecosystem: eco, module: name}
 	eco.modulesEnv[name] = env
 	eco.activesEnv[name] = env.layer([]string{}, []Value{})
 	return env
 }
+
+// definitions in a persistent module are saved as source text
+// the scratch module is never saved
+
+func (eco *Ecosystem) isPersistent(module string) bool {
+	return eco.storage != nil && module != scratchModule
+}
+
+func (eco *Ecosystem) saveSource(module string, name string, source string) error {
+	if !eco.isPersistent(module) {
+		return nil
+	}
+	return eco.storage.saveEntry(module, name, source)
+}
+
+// modules set up by initialize() rather than by the user
+
+var builtinModules = []string{"core", "shell", "config", "test", scratchModule}
+
+func isBuiltinModule(name string) bool {
+	for _, builtin := range builtinModules {
+		if builtin == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (eco *Ecosystem) checkModuleName(name string) error {
+	if name == "" || strings.Contains(name, moduleSep) {
+		return fmt.Errorf("invalid module name %s", name)
+	}
+	if _, ok := eco.modulesEnv[name]; ok {
+		return fmt.Errorf("module %s already exists", name)
+	}
+	return nil
+}
+
+func (eco *Ecosystem) checkUserModule(name string) error {
+	if _, ok := eco.modulesEnv[name]; !ok {
+		return fmt.Errorf("no such module %s", name)
+	}
+	if isBuiltinModule(name) {
+		return fmt.Errorf("cannot change built-in module %s", name)
+	}
+	return nil
+}
+
+// a module is only saved once it has definitions
+
+func (eco *Ecosystem) newModule(name string) error {
+	if err := eco.checkModuleName(name); err != nil {
+		return err
+	}
+	eco.mkEnv(name, map[string]Value{})
+	return nil
+}
+
+func (eco *Ecosystem) deleteModule(name string) error {
+	if err := eco.checkUserModule(name); err != nil {
+		return err
+	}
+	users, err := eco.referencingModules(name)
+	if err != nil {
+		return err
+	}
+	if len(users) > 0 {
+		return fmt.Errorf("module %s is still referenced by %s", name, strings.Join(users, ", "))
+	}
+	if eco.storage != nil {
+		if err := eco.storage.deleteModule(name); err != nil {
+			return err
+		}
+	}
+	delete(eco.modulesEnv, name)
+	delete(eco.activesEnv, name)
+	delete(eco.headers, name)
+	eco.index.dropModule(name)
+	return nil
+}
+
+// qualified references in stored source are rewritten, and the modules
+// whose source changed are reloaded so that their code sees the new name
+
+func (eco *Ecosystem) renameModule(name string, newName string) error {
+	if err := eco.checkUserModule(name); err != nil {
+		return err
+	}
+	if err := eco.checkModuleName(newName); err != nil {
+		return err
+	}
+	// users that neither import it nor name it in stored source would
+	// be left pointing at a module that no longer exists
+	users, err := eco.referencingModules(name)
+	if err != nil {
+		return err
+	}
+	stuck := []string{}
+	for _, user := range users {
+		if header, ok := eco.headers[user]; ok && header.importsModule(name) && eco.isPersistent(user) {
+			continue
+		}
+		if eco.isPersistent(user) {
+			refers, err := eco.storedRefersTo(user, name)
+			if err != nil {
+				return err
+			}
+			if refers {
+				continue
+			}
+		}
+		stuck = append(stuck, user)
+	}
+	if len(stuck) > 0 {
+		return fmt.Errorf("module %s is still used by %s, which cannot be rewritten", name, strings.Join(stuck, ", "))
+	}
+	env := eco.modulesEnv[name]
+	active := eco.activesEnv[name]
+	delete(eco.modulesEnv, name)
+	delete(eco.activesEnv, name)
+	env.module = newName
+	active.module = newName
+	eco.modulesEnv[newName] = env
+	eco.activesEnv[newName] = active
+	eco.index.renameModule(name, newName)
+	if header, ok := eco.headers[name]; ok {
+		delete(eco.headers, name)
+		header.name = newName
+		eco.headers[newName] = header
+	}
+	for _, header := range eco.headers {
+		for _, imp := range header.imports {
+			if imp.module == name {
+				imp.module = newName
+			}
+		}
+	}
+	if eco.storage == nil {
+		return nil
+	}
+	if err := eco.storage.renameModule(name, newName); err != nil {
+		return err
+	}
+	modules, err := eco.storage.getModules()
+	if err != nil {
+		return err
+	}
+	for _, module := range modules {
+		changed, err := eco.rewriteModule(module, name, newName)
+		if err != nil {
+			return err
+		}
+		if changed || module == newName {
+			// start afresh, since constants cannot be redefined
+			eco.modulesEnv[module].clear()
+			if _, err := eco.loadModule(eco.storage, module, eco.modulesEnv[module]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// rewrite the stored source of a module after renaming module name
+// returns whether anything changed
+
+func (eco *Ecosystem) rewriteModule(module string, name string, newName string) (bool, error) {
+	names, uids, err := eco.storage.getEntries(module)
+	if err != nil {
+		return false, err
+	}
+	changed := false
+	for i, uid := range uids {
+		src, err := eco.storage.readSource(uid)
+		if err != nil {
+			return false, err
+		}
+		newSrc := renameQualified(src, name, newName)
+		if header, ok := eco.headers[module]; ok && names[i] == kw_MODULE_HEADER && (module == newName || header.importsModule(newName)) {
+			newSrc = header.source() + "\n"
+		}
+		if newSrc == src {
+			continue
+		}
+		if err := eco.storage.saveEntry(module, names[i], strings.TrimSuffix(newSrc, "\n")); err != nil {
+			return false, err
+		}
+		changed = true
+	}
+	return changed, nil
+}
+
+func isDelimiter(c byte) bool {
+	return strings.IndexByte("\"'`,()[]#;", c) >= 0 || unicode.IsSpace(rune(c))
+}
+
+// replace name:: by newName:: at the start of symbols, leaving
+// strings and comments alone
+
+func renameQualified(src string, name string, newName string) string {
+	prefix := name + moduleSep
+	var result strings.Builder
+	inString := false
+	escape := false
+	inComment := false
+	blockDepth := 0
+	for i := 0; i < len(src); i++ {
+		c := src[i]
+		switch {
+		case inString:
+			if escape {
+				escape = false
+			} else if c == '\\' {
+				escape = true
+			} else if c == '"' {
+				inString = false
+			}
+		case inComment:
+			inComment = (c != '\n')
+		case strings.HasPrefix(src[i:], "#|"):
+			blockDepth += 1
+			result.WriteString("#")
+			i += 1
+			c = '|'
+		case blockDepth > 0:
+			if strings.HasPrefix(src[i:], "|#") {
+				blockDepth -= 1
+				result.WriteString("|")
+				i += 1
+				c = '#'
+			}
+		case c == '"':
+			inString = true
+		case c == ';':
+			inComment = true
+		case (i == 0 || isDelimiter(src[i - 1])) && strings.HasPrefix(src[i:], prefix):
+			result.WriteString(newName + moduleSep)
+			i += len(prefix) - 1
+			continue
+		}
+		result.WriteByte(c)
+	}
+	return result.String()
+}
+
+func refersTo(src string, module string) bool {
+	return renameQualified(src, module, "") != src
+}
+
+// whether the stored source of module uses qualified names of name
+
+func (eco *Ecosystem) storedRefersTo(module string, name string) (bool, error) {
+	if eco.storage == nil {
+		return false, nil
+	}
+	_, uids, err := eco.storage.getEntries(module)
+	if err != nil {
+		return false, err
+	}
+	for _, uid := range uids {
+		src, err := eco.storage.readSource(uid)
+		if err != nil {
+			return false, err
+		}
+		if refersTo(src, name) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// the modules other than name that import it or refer to its names
+// with qualified identifiers in their source, or whose live
+// definitions use its names, including unsaved modules and modules
+// using it through config::lookup-path
+
+func (eco *Ecosystem) referencingModules(name string) ([]string, error) {
+	users := map[string]bool{}
+	for module, header := range eco.headers {
+		if module != name && header.importsModule(name) {
+			users[module] = true
+		}
+	}
+	for ref := range eco.index.entries {
+		if ref.module == name || users[ref.module] {
+			continue
+		}
+		for _, dep := range eco.index.dependencies(ref) {
+			if dep.module == name {
+				users[ref.module] = true
+				break
+			}
+		}
+	}
+	if eco.storage != nil {
+		modules, err := eco.storage.getModules()
+		if err != nil {
+			return nil, err
+		}
+		for _, module := range modules {
+			if module == name || users[module] {
+				continue
+			}
+			refers, err := eco.storedRefersTo(module, name)
+			if err != nil {
+				return nil, err
+			}
+			if refers {
+				users[module] = true
+			}
+		}
+	}
+	result := []string{}
+	for module := range users {
+		result = append(result, module)
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+// the kind of a binding, as listed by module-bindings
+
+func bindingKind(env *Env, name string) string {
+	if env.kinds[name] == DEF_VAR {
+		return "variable"
+	}
+	if env.kinds[name] == DEF_TYPE {
+		return "type"
+	}
+	if env.kinds[name] == DEF_CLASS {
+		return "class"
+	}
+	switch env.bindings[name].(type) {
+	case *VPrimitive:
+		return "primitive"
+	case *VFunction:
+		return "function"
+	case *VMacro:
+		return "macro"
+	case *VGeneric:
+		return "generic"
+	}
+	return "constant"
+}
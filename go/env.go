@@ -1,12 +1,13 @@
 package main
 
-import "fmt"
 import "strings"
 
 type Env struct {
 	bindings map[string]Value
 	previous *Env
 	ecosystem *Ecosystem
+	module string      // the module this environment belongs to
+	kinds map[string]int     // how declared bindings were declared (DEF_ kinds)
 }
 
 const moduleSep = "::"
@@ -15,9 +16,9 @@ func (env *Env) find(name string) (Value, error) {
 	if strings.Contains(name, moduleSep) {
 		subnames := strings.Split(name, moduleSep)
 		if len(subnames) > 2 {
-			return nil, fmt.Errorf("multiple qualifiers in %s", name)
+			return nil, newError(ERR_UNBOUND, intern(name), "multiple qualifiers in %s", name)
 		}
-		return env.lookup(subnames[0], subnames[1])
+		return env.ecosystem.lookupFrom(env.module, subnames[0], subnames[1])
 	}
 	current := env
 	for current != nil {
@@ -27,32 +28,41 @@ func (env *Env) find(name string) (Value, error) {
 		}
 		current = current.previous
 	}
-	// can't find it, so look for it in the search path modules
-	lookup_path, err := env.lookup("config", "lookup-path")
-	if err != nil || !lookup_path.isRef() {
-		return nil, fmt.Errorf("no such identifier %s", name)
-	}
-	modules := lookup_path.getValue()
-	for modules.isCons() {
-		if modules.headValue().isSymbol() { 
-			result, err := env.lookup(modules.headValue().strValue(), name)
-			if err == nil {
-				return result, nil
-			}
+	// can't find it, so look for it in the modules imported by this one
+	return env.ecosystem.findImported(env.module, name)
+}
+
+// the module and name of the binding that find would return
+
+func (env *Env) resolve(name string) (string, string, bool) {
+	if strings.Contains(name, moduleSep) {
+		subnames := strings.Split(name, moduleSep)
+		if len(subnames) > 2 {
+			return "", "", false
+		}
+		if _, err := env.ecosystem.lookupFrom(env.module, subnames[0], subnames[1]); err != nil {
+			return "", "", false
 		}
-		modules = modules.tailValue()
+		return subnames[0], subnames[1], true
 	}
-	return nil, fmt.Errorf("no such identifier %s", name)
+	current := env
+	for current != nil {
+		if _, ok := current.bindings[name]; ok {
+			return current.module, name, true
+		}
+		current = current.previous
+	}
+	return env.ecosystem.resolveImported(env.module, name)
 }
 
 func (env *Env) lookup(module string, name string) (Value, error) {
 	moduleEnv, ok := env.ecosystem.modulesEnv[module]
 	if !ok {
-		return nil, fmt.Errorf("no such module %s", module)
+		return nil, newError(ERR_UNBOUND, intern(module), "no such module %s", module)
 	}
 	v, ok := moduleEnv.bindings[name]
 	if !ok {
-		return nil, fmt.Errorf("no such identifier %s", name)
+		return nil, newError(ERR_UNBOUND, intern(name), "no such identifier %s", name)
 	}
 	return v, nil
 }
@@ -61,6 +71,66 @@ func (env *Env) update(name string, v Value) {
 	env.bindings[name] = v
 }
 
+// bind a name as a declaration of the given kind
+// a constant cannot be redeclared
+
+func (env *Env) define(name string, v Value, kind int) error {
+	if env.kinds[name] == DEF_CONST {
+		return newError(ERR_ASSIGN, intern(name), "cannot redefine constant %s", name)
+	}
+	if env.kinds == nil {
+		env.kinds = map[string]int{}
+	}
+	env.update(name, v)
+	env.kinds[name] = kind
+	return nil
+}
+
+// set! changes a variable, or the content of a reference
+
+func (env *Env) assign(name string, v Value) error {
+	target, local, err := env.bindingEnv(name)
+	if err != nil {
+		return err
+	}
+	if target.kinds[local] == DEF_VAR {
+		target.update(local, v)
+		return nil
+	}
+	if current := target.bindings[local]; current.isRef() {
+		current.setValue(v)
+		return nil
+	}
+	if target.kinds[local] == DEF_CONST {
+		return newError(ERR_ASSIGN, intern(name), "cannot assign to constant %s", name)
+	}
+	return newError(ERR_ASSIGN, intern(name), "cannot assign to %s - not a variable", name)
+}
+
+// the environment holding the binding of a name, and the name there
+
+func (env *Env) bindingEnv(name string) (*Env, string, error) {
+	if _, err := env.find(name); err != nil {
+		return nil, "", err
+	}
+	for current := env; current != nil; current = current.previous {
+		if _, ok := current.bindings[name]; ok {
+			return current, name, nil
+		}
+	}
+	module, original, _ := env.resolve(name)
+	return env.ecosystem.modulesEnv[module], original, nil
+}
+
+// forget every binding, before reloading a module
+
+func (env *Env) clear() {
+	for name := range env.bindings {
+		delete(env.bindings, name)
+	}
+	env.kinds = nil
+}
+
 func (env *Env) layer(names []string, values []Value) *Env {
 	// if values is nil or smaller than names, then
 	// remaining names are bound to #nil
@@ -72,6 +142,6 @@ func (env *Env) layer(names []string, values []Value) *Env {
 			bindings[name] = &VNil{}
 		}
 	}
-	return &Env{bindings: bindings, previous: env, ecosystem: env.ecosystem}
+	return &Env{bindings: bindings, previous: env, ecosystem: env.ecosystem, module: env.module}
 }
 
@@ -0,0 +1,97 @@
+package main
+
+import "fmt"
+import "errors"
+import "strings"
+
+// Runtime errors
+//
+// Errors raised during evaluation carry a kind, a message, the
+// offending value if there is one, and the trace of the function
+// frames that were active when the error propagated out of them.
+// Innermost frame first.
+
+const ERR_ARITY = "arity"
+const ERR_TYPE = "type"
+const ERR_UNBOUND = "unbound"
+const ERR_INDEX = "index"
+const ERR_USER = "user"
+const ERR_MATCH = "match"
+const ERR_EXPORT = "export"
+const ERR_ASSIGN = "assign"
+const ERR_OTHER = "error"
+
+var ERR_KINDS = []string{ERR_ARITY, ERR_TYPE, ERR_UNBOUND, ERR_INDEX, ERR_USER, ERR_MATCH, ERR_EXPORT, ERR_ASSIGN, ERR_OTHER}
+
+func isErrorKind(kind string) bool {
+	for _, k := range ERR_KINDS {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+type RuntimeError struct {
+	kind string
+	msg string
+	value Value     // nil if no offending value
+	trace []*Frame
+	span *Span
+}
+
+type Frame struct {
+	fn *VFunction
+	span *Span      // where the function was called from, if known
+}
+
+func newError(kind string, value Value, format string, args ...interface{}) *RuntimeError {
+	return &RuntimeError{kind, fmt.Sprintf(format, args...), value, nil, nil}
+}
+
+func (e *RuntimeError) Error() string {
+	return e.msg
+}
+
+func (f *Frame) str() string {
+	name := f.fn.name
+	if name == "" || strings.HasPrefix(name, "__temp") {
+		// anonymous function
+		name = "<fn>"
+	}
+	if f.span == nil {
+		return name
+	}
+	return fmt.Sprintf("%s (called at %s)", name, f.span.str())
+}
+
+// the value seen by a handler for an error, whatever its kind
+// error-value gives the raised value of a user error
+
+func (e *RuntimeError) handlerValue() Value {
+	return &VError{e}
+}
+
+// any error coming out of evaluation can be seen as a runtime error
+
+func toRuntimeError(err error) *RuntimeError {
+	var rerr *RuntimeError
+	if errors.As(err, &rerr) {
+		if rerr.span == nil {
+			rerr.span = errorSpan(err)
+		}
+		return rerr
+	}
+	return &RuntimeError{ERR_OTHER, err.Error(), nil, nil, errorSpan(err)}
+}
+
+func traceError(err error, frame *Frame) error {
+	if frame == nil {
+		return err
+	}
+	// a copy, since the error may also be held by an error value that
+	// can be raised again
+	rerr := toRuntimeError(err)
+	trace := append(append([]*Frame{}, rerr.trace...), frame)
+	return &RuntimeError{rerr.kind, rerr.msg, rerr.value, trace, rerr.span}
+}
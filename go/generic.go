@@ -0,0 +1,475 @@
+package main
+
+import "strings"
+import "unicode/utf8"
+
+// Generic functions
+//
+// (defgeneric (area shape) 0)
+// (defmethod (area (s circle)) (* 3 (circle-r s) (circle-r s)))
+//
+// A generic function dispatches on the types of its required
+// parameters, as given by typ(), or on the variants of records. A
+// method gives a type or variant for some of those parameters, and
+// applies to arguments of these types. The most specific applicable
+// method is called, comparing parameters from left to right, a variant
+// being more specific than its type. When no method applies, the body of defgeneric is the
+// default method, and an error is raised if there is none.
+//
+// Methods can be added to a generic function from any module, so that
+// generic functions such as length can be extended to new types.
+
+const anyType = "_"
+
+type Method struct {
+	specs []string       // anyType for a parameter that is not specialized
+	fn Value
+}
+
+// a defmethod, before the method is created
+
+type MethodDef struct {
+	generic string
+	specs []string
+}
+
+// the name a method is saved under, such as length(point)
+
+func methodKey(generic string, specs []string) string {
+	return generic + "(" + strings.Join(specs, " ") + ")"
+}
+
+// how closely spec matches arg: 0 for any type, 1 for its type, 2 for
+// its variant, and -1 if it doesn't match
+
+func specMatch(spec string, arg Value) int {
+	if spec == anyType {
+		return 0
+	}
+	if r, ok := arg.(*VRecord); ok && spec == r.variant.name && spec != r.variant.typ.name {
+		return 2
+	}
+	if spec == arg.typ() {
+		return 1
+	}
+	return -1
+}
+
+func (m *Method) applies(args []Value) bool {
+	if len(args) < len(m.specs) {
+		return false
+	}
+	for i, spec := range m.specs {
+		if specMatch(spec, args[i]) < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (m *Method) moreSpecific(other *Method, args []Value) bool {
+	for i, spec := range m.specs {
+		match, otherMatch := specMatch(spec, args[i]), specMatch(other.specs[i], args[i])
+		if match > otherMatch {
+			return true
+		}
+		if match < otherMatch {
+			return false
+		}
+	}
+	return false
+}
+
+// a method replaces any method with the same types
+
+func (g *VGeneric) addMethod(specs []string, fn Value) {
+	key := methodKey(g.name, specs)
+	for _, m := range g.methods {
+		if methodKey(g.name, m.specs) == key {
+			m.fn = fn
+			return
+		}
+	}
+	g.methods = append(g.methods, &Method{specs, fn})
+}
+
+// the function to call on args
+
+func (g *VGeneric) dispatch(args []Value) (Value, error) {
+	var best *Method
+	for _, m := range g.methods {
+		if m.applies(args) && (best == nil || m.moreSpecific(best, args)) {
+			best = m
+		}
+	}
+	if best != nil {
+		return best.fn, nil
+	}
+	if g.dflt != nil {
+		return g.dflt, nil
+	}
+	return nil, noMethod(g.name, args)
+}
+
+var builtinTypes = []string{"int", "rational", "float", "bool", "fun", "list", "symbol", "macro", "string", "nil", "reference", "array", "dict", "error"}
+
+// whether methods can be given for name: a builtin type, a type or
+// variant of a deftype, or a class
+
+func (env *Env) isTypeName(name string) bool {
+	for _, builtin := range builtinTypes {
+		if name == builtin {
+			return true
+		}
+	}
+	envs := []*Env{}
+	for current := env; current != nil; current = current.previous {
+		envs = append(envs, current)
+	}
+	for _, moduleEnv := range env.ecosystem.modulesEnv {
+		envs = append(envs, moduleEnv)
+	}
+	for _, e := range envs {
+		for n, v := range e.bindings {
+			if c, ok := v.(*VConstructor); ok && (c.variant.name == name || c.variant.typ.name == name) {
+				return true
+			}
+			if n == name && e.kinds[n] == DEF_CLASS {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func noMethod(name string, args []Value) error {
+	types := make([]string, len(args))
+	for i, arg := range args {
+		types[i] = arg.typ()
+	}
+	return newError(ERR_TYPE, nil, "%s - no method for argument types (%s)", name, strings.Join(types, " "))
+}
+
+// generic functions of the core module, with their methods written as
+// primitives
+
+type GenericDesc struct {
+	name string
+	params *Params
+	dflt *PrimitiveDesc
+	methods []MethodDesc
+}
+
+type MethodDesc struct {
+	specs []string
+	prim PrimitiveDesc
+}
+
+func coreGenerics() map[string]Value {
+	bindings := map[string]Value{}
+	for _, d := range CORE_GENERICS {
+		g := &VGeneric{d.name, d.params, []*Method{}, nil}
+		if d.dflt != nil {
+			g.dflt = &VPrimitive{d.name, mkPrimitive(*d.dflt)}
+		}
+		for _, m := range d.methods {
+			g.addMethod(m.specs, &VPrimitive{d.name, mkPrimitive(m.prim)})
+		}
+		bindings[d.name] = g
+		primitiveTable[d.name] = coreDispatcher(d.name, g)
+	}
+	return bindings
+}
+
+// a primitive for #primitive("name") that dispatches among the core
+// methods only, so that it isn't affected by methods defined later
+
+func coreDispatcher(name string, g *VGeneric) *VPrimitive {
+	core := &VGeneric{g.name, g.params, append([]*Method{}, g.methods...), g.dflt}
+	return &VPrimitive{name, func(args []Value) (Value, error) {
+		fn, err := core.dispatch(args)
+		if err != nil {
+			return nil, err
+		}
+		return fn.(*VPrimitive).primitive(args)
+	}}
+}
+
+// the sequence operations
+
+var CORE_GENERICS = []GenericDesc{
+
+	GenericDesc{"length", simpleParams([]string{"seq"}), nil, []MethodDesc{
+		MethodDesc{[]string{"list"}, PrimitiveDesc{"length", 1, 1,
+			func(name string, args []Value) (Value, error) {
+				count := 0
+				current := args[0]
+				for current.isCons() {
+					count += 1
+					current = current.tailValue()
+				}
+				if !current.isEmpty() {
+					return nil, newError(ERR_TYPE, nil, "%s - malformed list", name)
+				}
+				return &VInteger{count}, nil
+			},
+		}},
+		MethodDesc{[]string{"string"}, PrimitiveDesc{"length", 1, 1,
+			func(name string, args []Value) (Value, error) {
+				return &VInteger{utf8.RuneCountInString(args[0].strValue())}, nil
+			},
+		}},
+		MethodDesc{[]string{"array"}, PrimitiveDesc{"length", 1, 1,
+			func(name string, args []Value) (Value, error) {
+				return &VInteger{len(args[0].getArray())}, nil
+			},
+		}},
+		MethodDesc{[]string{"dict"}, PrimitiveDesc{"length", 1, 1,
+			func(name string, args []Value) (Value, error) {
+				return &VInteger{len(args[0].getDict())}, nil
+			},
+		}},
+	}},
+
+	// (append) is the empty list
+
+	GenericDesc{"append", &Params{[]string{"seq"}, []string{}, []AST{}, "seqs"},
+		&PrimitiveDesc{"append", 0, -1,
+			func(name string, args []Value) (Value, error) {
+				if len(args) > 0 {
+					return nil, noMethod(name, args)
+				}
+				return &VEmpty{}, nil
+			},
+		},
+		[]MethodDesc{
+			MethodDesc{[]string{"list"}, PrimitiveDesc{"append", 1, -1,
+				func(name string, args []Value) (Value, error) {
+					if err := checkArgType(name, args[len(args) - 1], isList); err != nil {
+						return nil, err
+					}
+					result := args[len(args) - 1]
+					for i := len(args) - 2; i >= 0; i -= 1 {
+						if err := checkArgType(name, args[i], isList); err != nil {
+							return nil, err
+						}
+						result = listAppend(args[i], result)
+					}
+					return result, nil
+				},
+			}},
+			MethodDesc{[]string{"string"}, PrimitiveDesc{"append", 1, -1,
+				func(name string, args []Value) (Value, error) {
+					v := ""
+					for _, arg := range args {
+						if err := checkArgType(name, arg, isString); err != nil {
+							return nil, err
+						}
+						v += arg.strValue()
+					}
+					return &VString{v}, nil
+				},
+			}},
+			MethodDesc{[]string{"array"}, PrimitiveDesc{"append", 1, -1,
+				func(name string, args []Value) (Value, error) {
+					content := []Value{}
+					for _, arg := range args {
+						if err := checkArgType(name, arg, isArray); err != nil {
+							return nil, err
+						}
+						content = append(content, arg.getArray()...)
+					}
+					return &VArray{content}, nil
+				},
+			}},
+			// later dicts override the keys of earlier ones
+			MethodDesc{[]string{"dict"}, PrimitiveDesc{"append", 1, -1,
+				func(name string, args []Value) (Value, error) {
+					content := map[*VSymbol]Value{}
+					for _, arg := range args {
+						if err := checkArgType(name, arg, isDict); err != nil {
+							return nil, err
+						}
+						for k, v := range arg.getDict() {
+							content[k] = v
+						}
+					}
+					return &VDict{content}, nil
+				},
+			}},
+		},
+	},
+
+	// dicts have no order, so they cannot be reversed
+
+	GenericDesc{"reverse", simpleParams([]string{"seq"}), nil, []MethodDesc{
+		MethodDesc{[]string{"list"}, PrimitiveDesc{"reverse", 1, 1,
+			func(name string, args []Value) (Value, error) {
+				var result Value = &VEmpty{}
+				current := args[0]
+				for current.isCons() {
+					result = &VCons{head: current.headValue(), tail: result}
+					current = current.tailValue()
+				}
+				if !current.isEmpty() {
+					return nil, newError(ERR_TYPE, nil, "%s - malformed list", name)
+				}
+				return result, nil
+			},
+		}},
+		MethodDesc{[]string{"string"}, PrimitiveDesc{"reverse", 1, 1,
+			func(name string, args []Value) (Value, error) {
+				runes := []rune(args[0].strValue())
+				for i, j := 0, len(runes) - 1; i < j; i, j = i + 1, j - 1 {
+					runes[i], runes[j] = runes[j], runes[i]
+				}
+				return &VString{string(runes)}, nil
+			},
+		}},
+		MethodDesc{[]string{"array"}, PrimitiveDesc{"reverse", 1, 1,
+			func(name string, args []Value) (Value, error) {
+				content := args[0].getArray()
+				result := make([]Value, len(content))
+				for i, v := range content {
+					result[len(content) - 1 - i] = v
+				}
+				return &VArray{result}, nil
+			},
+		}},
+	}},
+
+	// mapping over several sequences stops at the shortest one
+
+	GenericDesc{"map", &Params{[]string{"f", "seq"}, []string{}, []AST{}, "seqs"}, nil, []MethodDesc{
+		MethodDesc{[]string{anyType, "list"}, PrimitiveDesc{"map", 2, -1,
+			func(name string, args []Value) (Value, error) {
+				if err := checkArgType(name, args[0], isFunction); err != nil {
+					return nil, err
+				}
+				for i := range args[1:] {
+					if err := checkArgType(name, args[i + 1], isList); err != nil {
+						return nil, err
+					}
+				}
+				var result Value = nil
+				var current_result *VCons = nil
+				currents := make([]Value, len(args) - 1)
+				firsts := make([]Value, len(args) - 1)
+				for i := range args[1:] {
+					currents[i] = args[i + 1]
+				}
+				for allConses(currents) {
+					for i := range currents {
+						firsts[i] = currents[i].headValue()
+					}
+					v, err := args[0].apply(firsts)
+					if err != nil {
+						return nil, err
+					}
+					cell := &VCons{head: v, tail: nil}
+					if current_result == nil {
+						result = cell
+					} else {
+						current_result.tail = cell
+					}
+					current_result = cell
+					for i := range currents {
+						currents[i] = currents[i].tailValue()
+					}
+				}
+				if current_result == nil {
+					return &VEmpty{}, nil
+				}
+				current_result.tail = &VEmpty{}
+				return result, nil
+			},
+		}},
+		// the function is applied to one-character strings, and must
+		// return strings
+		MethodDesc{[]string{anyType, "string"}, PrimitiveDesc{"map", 2, -1,
+			func(name string, args []Value) (Value, error) {
+				seqs := make([][]Value, len(args) - 1)
+				for i, arg := range args[1:] {
+					if err := checkArgType(name, arg, isString); err != nil {
+						return nil, err
+					}
+					for _, r := range arg.strValue() {
+						seqs[i] = append(seqs[i], &VString{string(r)})
+					}
+				}
+				items, err := mapItems(name, args[0], seqs)
+				if err != nil {
+					return nil, err
+				}
+				result := ""
+				for _, item := range items {
+					if err := checkArgType(name, item, isString); err != nil {
+						return nil, err
+					}
+					result += item.strValue()
+				}
+				return &VString{result}, nil
+			},
+		}},
+		MethodDesc{[]string{anyType, "array"}, PrimitiveDesc{"map", 2, -1,
+			func(name string, args []Value) (Value, error) {
+				seqs := make([][]Value, len(args) - 1)
+				for i, arg := range args[1:] {
+					if err := checkArgType(name, arg, isArray); err != nil {
+						return nil, err
+					}
+					seqs[i] = arg.getArray()
+				}
+				items, err := mapItems(name, args[0], seqs)
+				if err != nil {
+					return nil, err
+				}
+				return &VArray{items}, nil
+			},
+		}},
+		// the function is applied to the values, keeping the keys
+		MethodDesc{[]string{anyType, "dict"}, PrimitiveDesc{"map", 2, 2,
+			func(name string, args []Value) (Value, error) {
+				if err := checkArgType(name, args[0], isFunction); err != nil {
+					return nil, err
+				}
+				content := map[*VSymbol]Value{}
+				for k, v := range args[1].getDict() {
+					result, err := args[0].apply([]Value{v})
+					if err != nil {
+						return nil, err
+					}
+					content[k] = result
+				}
+				return &VDict{content}, nil
+			},
+		}},
+	}},
+}
+
+// apply f to the items at the same position in each sequence
+
+func mapItems(name string, f Value, seqs [][]Value) ([]Value, error) {
+	if err := checkArgType(name, f, isFunction); err != nil {
+		return nil, err
+	}
+	count := len(seqs[0])
+	for _, seq := range seqs {
+		if len(seq) < count {
+			count = len(seq)
+		}
+	}
+	result := make([]Value, count)
+	for i := range result {
+		items := make([]Value, len(seqs))
+		for j, seq := range seqs {
+			items[j] = seq[i]
+		}
+		v, err := f.apply(items)
+		if err != nil {
+			return nil, err
+		}
+		result[i] = v
+	}
+	return result, nil
+}
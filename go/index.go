@@ -0,0 +1,244 @@
+package main
+
+import "sort"
+
+// Cross-reference index
+//
+// For every definition, we keep the free identifiers of its code along
+// with the environment it was defined in. Identifiers are resolved to
+// the binding Env.find would give when the index is queried, so that
+// the index stays correct as definitions come and go.
+
+type Ref struct {
+	module string
+	name string
+}
+
+// source is only kept for unsaved modules, whose definitions cannot
+// be read back from storage when they have to be checked again
+
+type IndexEntry struct {
+	ids []string
+	def *Def
+	env *Env
+	source string
+}
+
+type Index struct {
+	entries map[Ref]*IndexEntry
+}
+
+func mkIndex() *Index {
+	return &Index{map[Ref]*IndexEntry{}}
+}
+
+func (r Ref) str() string {
+	return r.module + moduleSep + r.name
+}
+
+// walk the code of a definition, with its parameters bound
+
+func walkDef(d *Def, visit func(AST, map[string]bool)) {
+	bound := map[string]bool{}
+	if d.params != nil {
+		bound = bindNames(bound, d.params.names())
+		for _, dflt := range d.params.defaults {
+			dflt.walk(bound, visit)
+		}
+	}
+	if d.body != nil {
+		d.body.walk(bound, visit)
+	}
+	if d.class != nil {
+		walkClass(d.class, visit)
+	}
+}
+
+func walkClass(c *Class, visit func(AST, map[string]bool)) {
+	bound := bindNames(map[string]bool{}, c.params.names())
+	for _, dflt := range c.params.defaults {
+		dflt.walk(bound, visit)
+	}
+	for _, init := range c.inits {
+		init.walk(bound, visit)
+	}
+	bound = bindNames(bound, []string{thisName})
+	for i, body := range c.methodBodies {
+		methodBound := bindNames(bound, c.methodParams[i].names())
+		for _, dflt := range c.methodParams[i].defaults {
+			dflt.walk(methodBound, visit)
+		}
+		body.walk(methodBound, visit)
+	}
+}
+
+// the free identifiers of a definition, including the macros it uses
+
+func defIds(d *Def) []string {
+	ids := map[string]bool{}
+	walkDef(d, func(node AST, bound map[string]bool) {
+		switch e := node.(type) {
+		case *Id:
+			if !bound[e.name] && !isKeywordName(e.name) {
+				ids[e.name] = true
+			}
+		case *Set:
+			if !bound[e.name] {
+				ids[e.name] = true
+			}
+		}
+	})
+	for _, name := range d.macros {
+		ids[name] = true
+	}
+	names := []string{}
+	for name := range ids {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (idx *Index) add(d *Def, env *Env) {
+	idx.entries[Ref{env.module, d.name}] = &IndexEntry{defIds(d), d, env, ""}
+}
+
+func (idx *Index) dropModule(module string) {
+	for ref := range idx.entries {
+		if ref.module == module {
+			delete(idx.entries, ref)
+		}
+	}
+}
+
+func (idx *Index) renameModule(module string, newName string) {
+	for ref, entry := range idx.entries {
+		if ref.module == module {
+			delete(idx.entries, ref)
+			idx.entries[Ref{newName, ref.name}] = entry
+		}
+	}
+}
+
+// whether some class has a method of that name, in which case it need
+// not be bound since it may be called on an object
+
+func (idx *Index) isMethod(name string) bool {
+	for _, entry := range idx.entries {
+		if entry.def.class == nil {
+			continue
+		}
+		for _, method := range entry.def.class.methods {
+			if method == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// the bindings a definition uses directly
+
+func (idx *Index) dependencies(ref Ref) []Ref {
+	entry, ok := idx.entries[ref]
+	if !ok {
+		return []Ref{}
+	}
+	seen := map[Ref]bool{}
+	result := []Ref{}
+	for _, id := range entry.ids {
+		if _, err := entry.env.find(id); err != nil {
+			continue
+		}
+		module, name, ok := entry.env.resolve(id)
+		if !ok || seen[Ref{module, name}] {
+			continue
+		}
+		seen[Ref{module, name}] = true
+		result = append(result, Ref{module, name})
+	}
+	return sortRefs(result)
+}
+
+// the definitions that use a binding directly
+
+func (idx *Index) references(ref Ref) []Ref {
+	result := []Ref{}
+	for user := range idx.entries {
+		for _, dep := range idx.dependencies(user) {
+			if dep == ref {
+				result = append(result, user)
+				break
+			}
+		}
+	}
+	return sortRefs(result)
+}
+
+// the definitions naming a binding that can no longer be found, such
+// as an accessor of a type that was redefined without that field
+
+func (idx *Index) dangling(ref Ref) []Ref {
+	result := []Ref{}
+	for user, entry := range idx.entries {
+		for _, id := range entry.ids {
+			if id != ref.name && id != ref.str() {
+				continue
+			}
+			if _, err := entry.env.find(id); err != nil {
+				result = append(result, user)
+				break
+			}
+		}
+	}
+	return sortRefs(result)
+}
+
+// the transitive closure of a relation, not including ref itself
+// unless it is reachable from itself
+
+func (idx *Index) closure(ref Ref, next func(Ref) []Ref) []Ref {
+	seen := map[Ref]bool{}
+	result := []Ref{}
+	todo := next(ref)
+	for len(todo) > 0 {
+		current := todo[0]
+		todo = todo[1:]
+		if seen[current] {
+			continue
+		}
+		seen[current] = true
+		result = append(result, current)
+		todo = append(todo, next(current)...)
+	}
+	return sortRefs(result)
+}
+
+func sortRefs(refs []Ref) []Ref {
+	sort.Slice(refs, func(i, j int) bool {
+		return refs[i].str() < refs[j].str()
+	})
+	return refs
+}
+
+// the binding a name refers to from the current module of the shell
+
+func shellRef(name string, v Value) (Ref, error) {
+	if err := checkArgType(name, v, isSymbol); err != nil {
+		return Ref{}, err
+	}
+	env := context.ecosystem.activesEnv[context.currentModule]
+	if _, err := env.find(v.strValue()); err != nil {
+		return Ref{}, err
+	}
+	module, original, _ := env.resolve(v.strValue())
+	return Ref{module, original}, nil
+}
+
+func refsToList(refs []Ref) Value {
+	var result Value = &VEmpty{}
+	for i := len(refs) - 1; i >= 0; i-- {
+		result = &VCons{head: intern(refs[i].str()), tail: result}
+	}
+	return result
+}
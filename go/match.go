@@ -0,0 +1,235 @@
+package main
+
+import "fmt"
+import "strings"
+
+// Pattern matching
+//
+// (match expr (pattern body) ...) with guarded clauses (pattern when guard body)
+//
+// Patterns are compiled at parse time. Each variable in a clause's
+// pattern gets an index, and a successful match fills in the values
+// at those indices, which are then bound with Env.layer.
+
+type Pattern interface {
+	match(Value, []Value) bool
+	str() string
+}
+
+type PWildcard struct {
+}
+
+type PVariable struct {
+	name string
+	index int
+}
+
+type PLiteral struct {
+	val Value
+}
+
+type PCons struct {
+	head Pattern
+	tail Pattern
+}
+
+type PArray struct {
+	elems []Pattern
+	rest Pattern     // nil if no rest pattern
+}
+
+type PDict struct {
+	keys []*VSymbol
+	pats []Pattern
+}
+
+type PRecord struct {
+	variant *Variant
+	pats []Pattern
+}
+
+type MatchClause struct {
+	pattern Pattern
+	names []string
+	guard AST        // nil if no guard
+	body AST
+}
+
+type Match struct {
+	exp AST
+	clauses []*MatchClause
+	span *Span
+}
+
+func (p *PWildcard) match(v Value, values []Value) bool {
+	return true
+}
+
+func (p *PWildcard) str() string {
+	return "_"
+}
+
+func (p *PVariable) match(v Value, values []Value) bool {
+	values[p.index] = v
+	return true
+}
+
+func (p *PVariable) str() string {
+	return p.name
+}
+
+func (p *PLiteral) match(v Value, values []Value) bool {
+	return p.val.isEqual(v)
+}
+
+func (p *PLiteral) str() string {
+	return fmt.Sprintf("'%s", p.val.display())
+}
+
+func (p *PCons) match(v Value, values []Value) bool {
+	if !v.isCons() {
+		return false
+	}
+	return p.head.match(v.headValue(), values) && p.tail.match(v.tailValue(), values)
+}
+
+func (p *PCons) str() string {
+	return fmt.Sprintf("(%s . %s)", p.head.str(), p.tail.str())
+}
+
+// an array pattern with a rest pattern matches arrays with at least
+// as many elements, and matches the remaining elements as an array
+
+func (p *PArray) match(v Value, values []Value) bool {
+	if !v.isArray() {
+		return false
+	}
+	content := v.getArray()
+	if len(content) < len(p.elems) || (p.rest == nil && len(content) > len(p.elems)) {
+		return false
+	}
+	for i, elem := range p.elems {
+		if !elem.match(content[i], values) {
+			return false
+		}
+	}
+	if p.rest != nil {
+		rest := make([]Value, len(content) - len(p.elems))
+		copy(rest, content[len(p.elems):])
+		return p.rest.match(&VArray{rest}, values)
+	}
+	return true
+}
+
+func (p *PArray) str() string {
+	items := make([]string, len(p.elems))
+	for i, elem := range p.elems {
+		items[i] = elem.str()
+	}
+	if p.rest != nil {
+		items = append(items, ".", p.rest.str())
+	}
+	return fmt.Sprintf("#[%s]", strings.Join(items, " "))
+}
+
+// a dict pattern matches dicts that have at least the given keys
+
+func (p *PDict) match(v Value, values []Value) bool {
+	if !v.isDict() {
+		return false
+	}
+	content := v.getDict()
+	for i, key := range p.keys {
+		item, ok := content[key]
+		if !ok || !p.pats[i].match(item, values) {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *PDict) str() string {
+	items := make([]string, len(p.keys))
+	for i, key := range p.keys {
+		items[i] = fmt.Sprintf("(%s %s)", key.name, p.pats[i].str())
+	}
+	return fmt.Sprintf("#dict(%s)", strings.Join(items, " "))
+}
+
+func (p *PRecord) match(v Value, values []Value) bool {
+	r, ok := v.(*VRecord)
+	if !ok || r.variant != p.variant {
+		return false
+	}
+	for i, pat := range p.pats {
+		if !pat.match(r.fields[i], values) {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *PRecord) str() string {
+	items := []string{p.variant.name}
+	for _, pat := range p.pats {
+		items = append(items, pat.str())
+	}
+	return fmt.Sprintf("(%s)", strings.Join(items, " "))
+}
+
+func (e *Match) eval(env *Env) (Value, error) {
+	return defaultEval(e, env)
+}
+
+func (e *Match) evalPartial(env *Env) (*PartialResult, error) {
+	v, err := e.exp.eval(env)
+	if err != nil {
+		return nil, locate(err, e.span)
+	}
+	for _, clause := range e.clauses {
+		values := make([]Value, len(clause.names))
+		if !clause.pattern.match(v, values) {
+			continue
+		}
+		newEnv := env.layer(clause.names, values)
+		if clause.guard != nil {
+			g, err := clause.guard.eval(newEnv)
+			if err != nil {
+				return nil, locate(err, e.span)
+			}
+			if !g.isTrue() {
+				continue
+			}
+		}
+		return &PartialResult{clause.body, newEnv, nil, nil}, nil
+	}
+	return nil, locate(newError(ERR_MATCH, v, "no clause matches %s", v.display()), e.span)
+}
+
+func (e *Match) str() string {
+	clauses := make([]string, len(e.clauses))
+	for i, clause := range e.clauses {
+		if clause.guard != nil {
+			clauses[i] = fmt.Sprintf("[%s when %s %s]", clause.pattern.str(), clause.guard.str(), clause.body.str())
+		} else {
+			clauses[i] = fmt.Sprintf("[%s %s]", clause.pattern.str(), clause.body.str())
+		}
+	}
+	return fmt.Sprintf("Match[%s %s]", e.exp.str(), strings.Join(clauses, " "))
+}
+
+func (e *Match) getSpan() *Span {
+	return e.span
+}
+
+func (e *Match) walk(bound map[string]bool, visit func(AST, map[string]bool)) {
+	visit(e, bound)
+	e.exp.walk(bound, visit)
+	for _, clause := range e.clauses {
+		clauseBound := bindNames(bound, clause.names)
+		if clause.guard != nil {
+			clause.guard.walk(clauseBound, visit)
+		}
+		clause.body.walk(clauseBound, visit)
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import "strings"
+
+// Module headers
+//
+// (module-header name (import spec ...) (export name ...))
+//
+// An import spec is either a module name, which makes every name that
+// module exports visible, or (module name ...) which imports only the
+// given names. A name can be renamed on import with (name local).
+//
+// Without an export clause, a module exports all its names except
+// private ones, which are the names starting with _.
+//
+// A name not defined in a module is looked up in the names it imports
+// explicitly, then in the modules it imports in order, then in the
+// modules every module imports. Modules without a header use
+// config::lookup-path instead.
+
+const privatePrefix = "_"
+
+var implicitImports = []string{"core", "shell"}
+
+type ModuleHeader struct {
+	name string
+	imports []*Import
+	exports []string     // nil if no export clause
+}
+
+type Import struct {
+	module string
+	names []string       // nil if importing the whole module
+	locals []string      // the names they are imported as
+}
+
+func isPrivateName(name string) bool {
+	return strings.HasPrefix(name, privatePrefix)
+}
+
+func (h *ModuleHeader) str() string {
+	imports := make([]string, len(h.imports))
+	for i, imp := range h.imports {
+		if imp.names == nil {
+			imports[i] = imp.module
+			continue
+		}
+		names := make([]string, len(imp.names))
+		for j, name := range imp.names {
+			if name == imp.locals[j] {
+				names[j] = name
+			} else {
+				names[j] = name + "->" + imp.locals[j]
+			}
+		}
+		imports[i] = imp.module + "[" + strings.Join(names, " ") + "]"
+	}
+	result := "ModuleHeader[" + h.name + " import[" + strings.Join(imports, " ") + "]"
+	if h.exports != nil {
+		result += " export[" + strings.Join(h.exports, " ") + "]"
+	}
+	return result + "]"
+}
+
+func (h *ModuleHeader) importsModule(module string) bool {
+	for _, imp := range h.imports {
+		if imp.module == module {
+			return true
+		}
+	}
+	return false
+}
+
+// the source text of a header, used when a module is renamed
+
+func (h *ModuleHeader) source() string {
+	specs := make([]string, len(h.imports))
+	for i, imp := range h.imports {
+		if imp.names == nil {
+			specs[i] = imp.module
+			continue
+		}
+		names := make([]string, len(imp.names))
+		for j, name := range imp.names {
+			if name == imp.locals[j] {
+				names[j] = name
+			} else {
+				names[j] = "(" + name + " " + imp.locals[j] + ")"
+			}
+		}
+		specs[i] = "(" + strings.Join(append([]string{imp.module}, names...), " ") + ")"
+	}
+	result := "(" + kw_MODULE_HEADER + " " + h.name
+	if len(specs) > 0 {
+		result += "\n  (" + kw_IMPORT + " " + strings.Join(specs, " ") + ")"
+	}
+	if h.exports != nil {
+		result += "\n  (" + strings.Join(append([]string{kw_EXPORT}, h.exports...), " ") + ")"
+	}
+	return result + ")"
+}
+
+func (eco *Ecosystem) isExported(module string, name string) bool {
+	if isPrivateName(name) {
+		return false
+	}
+	header, ok := eco.headers[module]
+	if !ok || header.exports == nil {
+		return true
+	}
+	for _, exported := range header.exports {
+		if exported == name {
+			return true
+		}
+	}
+	return false
+}
+
+// look up a name defined in a module, as seen from module from
+
+func (eco *Ecosystem) lookupFrom(from string, module string, name string) (Value, error) {
+	moduleEnv, ok := eco.modulesEnv[module]
+	if !ok {
+		return nil, newError(ERR_UNBOUND, intern(module), "no such module %s", module)
+	}
+	v, ok := moduleEnv.bindings[name]
+	if !ok {
+		return nil, newError(ERR_UNBOUND, intern(module + moduleSep + name), "no such identifier %s%s%s", module, moduleSep, name)
+	}
+	if from != module && !eco.isExported(module, name) {
+		return nil, newError(ERR_EXPORT, intern(module + moduleSep + name), "%s is not exported by module %s", name, module)
+	}
+	return v, nil
+}
+
+// look up a name not defined in module from
+
+func (eco *Ecosystem) findImported(from string, name string) (Value, error) {
+	module, original, ok := eco.resolveImported(from, name)
+	if !ok {
+		return nil, newError(ERR_UNBOUND, intern(name), "no such identifier %s", name)
+	}
+	return eco.lookupFrom(from, module, original)
+}
+
+// the module a name not defined in module from comes from, and its
+// name in that module
+
+func (eco *Ecosystem) resolveImported(from string, name string) (string, string, bool) {
+	header, ok := eco.headers[from]
+	if !ok {
+		for _, module := range eco.lookupPath() {
+			if _, err := eco.lookupFrom(from, module, name); err == nil {
+				return module, name, true
+			}
+		}
+		return "", "", false
+	}
+	for _, imp := range header.imports {
+		for i, local := range imp.locals {
+			if local == name {
+				return imp.module, imp.names[i], true
+			}
+		}
+	}
+	for _, imp := range header.imports {
+		if imp.names != nil {
+			continue
+		}
+		if _, err := eco.lookupFrom(from, imp.module, name); err == nil {
+			return imp.module, name, true
+		}
+	}
+	for _, module := range implicitImports {
+		if _, err := eco.lookupFrom(from, module, name); err == nil {
+			return module, name, true
+		}
+	}
+	return "", "", false
+}
+
+// the modules in config::lookup-path, used by modules without a header
+
+func (eco *Ecosystem) lookupPath() []string {
+	result := []string{}
+	lookupPath, err := eco.lookupFrom("", "config", "lookup-path")
+	if err != nil || !lookupPath.isRef() {
+		return result
+	}
+	modules := lookupPath.getValue()
+	for modules.isCons() {
+		if modules.headValue().isSymbol() {
+			result = append(result, modules.headValue().strValue())
+		}
+		modules = modules.tailValue()
+	}
+	return result
+}
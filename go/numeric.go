@@ -0,0 +1,331 @@
+package main
+
+import "math"
+import "math/big"
+import "strconv"
+import "strings"
+
+// Numeric tower
+//
+// int < rational < float
+//
+// Ints are VInteger when they fit in an int and VBigInt otherwise.
+// Exact operations produce the simplest exact value that holds the
+// result (so overflow promotes to VBigInt, and a rational with
+// denominator 1 is an int). Anything involving a float is a float.
+
+const NUM_INT = 0
+const NUM_BIGINT = 1
+const NUM_RATIONAL = 2
+const NUM_FLOAT = 3
+
+func numLevel(v Value) int {
+	switch v.(type) {
+	case *VInteger:
+		return NUM_INT
+	case *VBigInt:
+		return NUM_BIGINT
+	case *VRational:
+		return NUM_RATIONAL
+	}
+	return NUM_FLOAT
+}
+
+func isExactInteger(v Value) bool {
+	level := numLevel(v)
+	return v.isNumber() && (level == NUM_INT || level == NUM_BIGINT)
+}
+
+func isExact(v Value) bool {
+	return v.isNumber() && numLevel(v) != NUM_FLOAT
+}
+
+func isZero(v Value) bool {
+	return !v.isTrue()
+}
+
+func toBig(v Value) *big.Int {
+	switch n := v.(type) {
+	case *VInteger:
+		return big.NewInt(int64(n.val))
+	case *VBigInt:
+		return n.val
+	}
+	panic("unchecked conversion to big integer of " + v.str())
+}
+
+func toRat(v Value) *big.Rat {
+	if r, ok := v.(*VRational); ok {
+		return r.val
+	}
+	return new(big.Rat).SetInt(toBig(v))
+}
+
+func toFloat(v Value) float64 {
+	switch n := v.(type) {
+	case *VInteger:
+		return float64(n.val)
+	case *VBigInt:
+		f, _ := new(big.Float).SetInt(n.val).Float64()
+		return f
+	case *VRational:
+		f, _ := n.val.Float64()
+		return f
+	case *VFloat:
+		return n.val
+	}
+	panic("unchecked conversion to float of " + v.str())
+}
+
+func normalizeBig(n *big.Int) Value {
+	if n.IsInt64() && int64(int(n.Int64())) == n.Int64() {
+		return &VInteger{int(n.Int64())}
+	}
+	return &VBigInt{n}
+}
+
+func normalizeRat(r *big.Rat) Value {
+	if r.IsInt() {
+		return normalizeBig(new(big.Int).Set(r.Num()))
+	}
+	return &VRational{r}
+}
+
+func displayFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+inf.0"
+	case math.IsInf(f, -1):
+		return "-inf.0"
+	case math.IsNaN(f):
+		return "+nan.0"
+	}
+	s := strconv.FormatFloat(f, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".e") {
+		// keep floats distinguishable from ints
+		s += ".0"
+	}
+	return s
+}
+
+func numAdd(a Value, b Value) Value {
+	switch max(numLevel(a), numLevel(b)) {
+	case NUM_INT:
+		x, y := a.intValue(), b.intValue()
+		r := x + y
+		if (x ^ r) & (y ^ r) >= 0 {
+			return &VInteger{r}
+		}
+		return normalizeBig(new(big.Int).Add(toBig(a), toBig(b)))
+	case NUM_BIGINT:
+		return normalizeBig(new(big.Int).Add(toBig(a), toBig(b)))
+	case NUM_RATIONAL:
+		return normalizeRat(new(big.Rat).Add(toRat(a), toRat(b)))
+	}
+	return &VFloat{toFloat(a) + toFloat(b)}
+}
+
+func numSub(a Value, b Value) Value {
+	switch max(numLevel(a), numLevel(b)) {
+	case NUM_INT:
+		x, y := a.intValue(), b.intValue()
+		r := x - y
+		if (x ^ y) & (x ^ r) >= 0 {
+			return &VInteger{r}
+		}
+		return normalizeBig(new(big.Int).Sub(toBig(a), toBig(b)))
+	case NUM_BIGINT:
+		return normalizeBig(new(big.Int).Sub(toBig(a), toBig(b)))
+	case NUM_RATIONAL:
+		return normalizeRat(new(big.Rat).Sub(toRat(a), toRat(b)))
+	}
+	return &VFloat{toFloat(a) - toFloat(b)}
+}
+
+func numMul(a Value, b Value) Value {
+	switch max(numLevel(a), numLevel(b)) {
+	case NUM_INT:
+		x, y := a.intValue(), b.intValue()
+		if x == 0 || y == 0 {
+			return &VInteger{0}
+		}
+		r := x * y
+		if r / y == x && !(x == -1 && y == math.MinInt) && !(y == -1 && x == math.MinInt) {
+			return &VInteger{r}
+		}
+		return normalizeBig(new(big.Int).Mul(toBig(a), toBig(b)))
+	case NUM_BIGINT:
+		return normalizeBig(new(big.Int).Mul(toBig(a), toBig(b)))
+	case NUM_RATIONAL:
+		return normalizeRat(new(big.Rat).Mul(toRat(a), toRat(b)))
+	}
+	return &VFloat{toFloat(a) * toFloat(b)}
+}
+
+// exact division gives a rational, so (/ 1 2) is 1/2
+
+func numDiv(name string, a Value, b Value) (Value, error) {
+	if isExact(b) && isZero(b) {
+		return nil, newError(ERR_OTHER, a, "%s - division by zero", name)
+	}
+	if max(numLevel(a), numLevel(b)) == NUM_FLOAT {
+		return &VFloat{toFloat(a) / toFloat(b)}, nil
+	}
+	return normalizeRat(new(big.Rat).Quo(toRat(a), toRat(b))), nil
+}
+
+func numCompare(a Value, b Value) int {
+	switch max(numLevel(a), numLevel(b)) {
+	case NUM_INT:
+		x, y := a.intValue(), b.intValue()
+		if x < y {
+			return -1
+		}
+		if x > y {
+			return 1
+		}
+		return 0
+	case NUM_FLOAT:
+		x, y := toFloat(a), toFloat(b)
+		if x < y {
+			return -1
+		}
+		if x > y {
+			return 1
+		}
+		return 0
+	}
+	return toRat(a).Cmp(toRat(b))
+}
+
+// Euclidean division of the numerator by the (positive) denominator is a floor
+func floorRat(r *big.Rat) *big.Int {
+	q, m := new(big.Int), new(big.Int)
+	q.DivMod(r.Num(), r.Denom(), m)
+	return q
+}
+
+func numFloor(v Value) Value {
+	switch numLevel(v) {
+	case NUM_RATIONAL:
+		return normalizeBig(floorRat(toRat(v)))
+	case NUM_FLOAT:
+		return &VFloat{math.Floor(toFloat(v))}
+	}
+	return v
+}
+
+// round to even on ties, like Scheme
+
+func numRound(v Value) Value {
+	switch numLevel(v) {
+	case NUM_RATIONAL:
+		r := toRat(v)
+		f := floorRat(r)
+		diff := new(big.Rat).Sub(r, new(big.Rat).SetInt(f))
+		c := diff.Cmp(big.NewRat(1, 2))
+		if c > 0 || (c == 0 && f.Bit(0) == 1) {
+			f.Add(f, big.NewInt(1))
+		}
+		return normalizeBig(f)
+	case NUM_FLOAT:
+		return &VFloat{math.RoundToEven(toFloat(v))}
+	}
+	return v
+}
+
+func exactSqrt(n *big.Int) (*big.Int, bool) {
+	s := new(big.Int).Sqrt(n)
+	return s, new(big.Int).Mul(s, s).Cmp(n) == 0
+}
+
+// exact when the argument is the square of an exact number
+
+func numSqrt(name string, v Value) (Value, error) {
+	if numCompare(v, &VInteger{0}) < 0 {
+		return nil, newError(ERR_OTHER, v, "%s - negative argument %s", name, v.display())
+	}
+	if isExact(v) {
+		r := toRat(v)
+		num, ok1 := exactSqrt(r.Num())
+		den, ok2 := exactSqrt(r.Denom())
+		if ok1 && ok2 {
+			return normalizeRat(new(big.Rat).SetFrac(num, den)), nil
+		}
+	}
+	return &VFloat{math.Sqrt(toFloat(v))}, nil
+}
+
+// exact when the base is exact and the exponent an exact integer
+
+func numExpt(name string, base Value, exp Value) (Value, error) {
+	if !isExact(base) || !isExactInteger(exp) {
+		return &VFloat{math.Pow(toFloat(base), toFloat(exp))}, nil
+	}
+	e := toBig(exp)
+	r := toRat(base)
+	abs := new(big.Int).Abs(e)
+	num := new(big.Int).Exp(r.Num(), abs, nil)
+	den := new(big.Int).Exp(r.Denom(), abs, nil)
+	if e.Sign() >= 0 {
+		return normalizeRat(new(big.Rat).SetFrac(num, den)), nil
+	}
+	if num.Sign() == 0 {
+		return nil, newError(ERR_OTHER, base, "%s - division by zero", name)
+	}
+	return normalizeRat(new(big.Rat).SetFrac(den, num)), nil
+}
+
+func numExactToInexact(v Value) Value {
+	return &VFloat{toFloat(v)}
+}
+
+func numInexactToExact(name string, v Value) (Value, error) {
+	if isExact(v) {
+		return v, nil
+	}
+	r := new(big.Rat).SetFloat64(toFloat(v))
+	if r == nil {
+		return nil, newError(ERR_TYPE, v, "%s - no exact value for %s", name, v.display())
+	}
+	return normalizeRat(r), nil
+}
+
+// integer division: quotient truncates, remainder has the sign of
+// the dividend, modulo has the sign of the divisor
+
+func numIntDiv(name string, a Value, b Value) (*big.Int, *big.Int, error) {
+	if isZero(b) {
+		return nil, nil, newError(ERR_OTHER, a, "%s - division by zero", name)
+	}
+	q, r := new(big.Int).QuoRem(toBig(a), toBig(b), new(big.Int))
+	return q, r, nil
+}
+
+func numQuotient(name string, a Value, b Value) (Value, error) {
+	q, _, err := numIntDiv(name, a, b)
+	if err != nil {
+		return nil, err
+	}
+	return normalizeBig(q), nil
+}
+
+func numRemainder(name string, a Value, b Value) (Value, error) {
+	_, r, err := numIntDiv(name, a, b)
+	if err != nil {
+		return nil, err
+	}
+	return normalizeBig(r), nil
+}
+
+func numModulo(name string, a Value, b Value) (Value, error) {
+	_, r, err := numIntDiv(name, a, b)
+	if err != nil {
+		return nil, err
+	}
+	d := toBig(b)
+	if r.Sign() != 0 && r.Sign() != d.Sign() {
+		r.Add(r, d)
+	}
+	return normalizeBig(r), nil
+}
@@ -4,18 +4,172 @@ import "errors"
 import "fmt"
 
 const kw_DEF string = "def"
+const kw_CONST string = "const"
+const kw_VAR string = "var"
+const kw_SET string = "set!"
+const kw_DEFTYPE string = "deftype"
+const kw_CLASS string = "class"
+const kw_FIELD string = "field"
+const kw_FIELD_GET string = "field-get"
+const kw_FIELD_SET string = "field-set"
+const kw_DEFGENERIC string = "defgeneric"
+const kw_DEFMETHOD string = "defmethod"
 const kw_LET string = "let"
 const kw_LETSTAR string = "let*"
 const kw_LETREC string = "letrec"
-const kw_LOOP string = "let"
+const kw_LOOP string = "loop"
 const kw_IF string = "if"
 const kw_FUN string = "fn"
+const kw_FUNREC string = "funrec"
 const kw_QUOTE string = "quote"
+const kw_QUASIQUOTE string = "quasiquote"
+const kw_UNQUOTE string = "unquote"
+const kw_UNQUOTESPLICING string = "unquote-splicing"
 const kw_DO string = "do"
+const kw_RAISE string = "raise"
+const kw_TRY string = "try"
+const kw_CATCH string = "catch"
+const kw_CATCHIF string = "catch-if"
+const kw_FINALLY string = "finally"
 
 const kw_MACRO string = "macro"
 const kw_AND string = "and"
 const kw_OR string = "or"
+const kw_COND string = "cond"
+const kw_WHEN string = "when"
+const kw_UNLESS string = "unless"
+const kw_CASE string = "case"
+const kw_ELSE string = "else"
+const kw_MATCH string = "match"
+const kw_MODULE_HEADER string = "module-header"
+const kw_IMPORT string = "import"
+const kw_EXPORT string = "export"
+const kw_WILDCARD string = "_"
+const kw_ARRAY string = "array"
+const kw_DICT string = "dict"
+
+// a parser turns s-expressions into ASTs, carrying over the source
+// spans recorded by the reader when there are any
+// macros are looked up in env, if there is one
+
+type Parser struct {
+	spans map[Value]*Span
+	heads map[Value]*Span    // the spans of the heads of list cells
+	env *Env
+	macros []string     // the macros expanded so far
+}
+
+func newParser(r *Reader, env *Env) *Parser {
+	if r == nil {
+		return &Parser{map[Value]*Span{}, map[Value]*Span{}, env, []string{}}
+	}
+	return &Parser{r.spans, r.heads, env, []string{}}
+}
+
+// parse a top-level form, either a declaration or an expression
+
+func (p *Parser) parseTop(sexp Value) (*Def, AST, error) {
+	sexp, err := p.expand(sexp)
+	if err != nil {
+		return nil, nil, err
+	}
+	d, err := p.parseDef(sexp)
+	if err != nil {
+		return nil, nil, err
+	}
+	if d != nil {
+		d.macros = p.macros
+		return d, nil, nil
+	}
+	e, err := p.parseExpr(sexp)
+	return nil, e, err
+}
+
+// expand macro calls at the head of sexp until there are none left
+
+func (p *Parser) expand(sexp Value) (Value, error) {
+	for p.env != nil && sexp.isCons() && sexp.headValue().isSymbol() {
+		v, err := p.env.find(sexp.headValue().strValue())
+		if err != nil {
+			return sexp, nil
+		}
+		macro, ok := v.(*VMacro)
+		if !ok {
+			return sexp, nil
+		}
+		p.macros = append(p.macros, sexp.headValue().strValue())
+		args := make([]Value, 0)
+		current := sexp.tailValue()
+		for current.isCons() {
+			args = append(args, current.headValue())
+			current = current.tailValue()
+		}
+		if !current.isEmpty() {
+			return nil, p.errorAt(sexp, "malformed macro call")
+		}
+		result, err := macro.fn.apply(args)
+		if err != nil {
+			return nil, locate(err, p.spanOf(sexp))
+		}
+		p.inheritSpan(result, p.spanOf(sexp))
+		sexp = result
+	}
+	return sexp, nil
+}
+
+// code produced by a macro is reported at the macro call
+
+func (p *Parser) inheritSpan(sexp Value, span *Span) {
+	if span == nil {
+		return
+	}
+	for {
+		if _, ok := p.spans[sexp]; ok {
+			return
+		}
+		p.spans[sexp] = span
+		if !sexp.isCons() {
+			return
+		}
+		if _, ok := p.heads[sexp]; !ok {
+			p.heads[sexp] = span
+		}
+		p.inheritSpan(sexp.headValue(), span)
+		sexp = sexp.tailValue()
+	}
+}
+
+func (p *Parser) spanOf(sexp Value) *Span {
+	return p.spans[sexp]
+}
+
+func (p *Parser) errorAt(sexp Value, msg string) error {
+	return locate(errors.New(msg), p.spanOf(sexp))
+}
+
+// the span of the head of a list cell, which tells apart the
+// occurrences of a symbol
+
+func (p *Parser) headSpan(cell Value) *Span {
+	if span, ok := p.heads[cell]; ok {
+		return span
+	}
+	return p.spanOf(cell.headValue())
+}
+
+func (p *Parser) errorAtHead(cell Value, msg string) error {
+	return locate(errors.New(msg), p.headSpan(cell))
+}
+
+// keywords evaluate to themselves, so they cannot be bound
+
+func (p *Parser) checkBinder(cell Value) error {
+	name := cell.headValue()
+	if name.isSymbol() && isKeywordName(name.strValue()) {
+		return p.errorAtHead(cell, "cannot bind keyword " + name.strValue())
+	}
+	return nil
+}
 
 var fresh = (func(init int) func(string)string { 
 	id := init
@@ -26,109 +180,194 @@ var fresh = (func(init int) func(string)string {
 	}
 })(0)
 
-func parseDef(sexp Value) (*Def, error) {
+func (p *Parser) parseDef(sexp Value) (*Def, error) {
 	if !sexp.isCons() {
 		return nil, nil
 	}
+	if parseKeyword(kw_MACRO, sexp.headValue()) {
+		return p.parseMacro(sexp)
+	}
+	if parseKeyword(kw_MODULE_HEADER, sexp.headValue()) {
+		return p.parseModuleHeader(sexp)
+	}
+	if parseKeyword(kw_CONST, sexp.headValue()) {
+		return p.parseDeclaration(kw_CONST, DEF_CONST, sexp)
+	}
+	if parseKeyword(kw_DEFTYPE, sexp.headValue()) {
+		return p.parseDefType(sexp)
+	}
+	if parseKeyword(kw_VAR, sexp.headValue()) {
+		return p.parseDeclaration(kw_VAR, DEF_VAR, sexp)
+	}
+	if parseKeyword(kw_CLASS, sexp.headValue()) {
+		return p.parseClass(sexp)
+	}
+	if parseKeyword(kw_DEFGENERIC, sexp.headValue()) {
+		return p.parseGeneric(sexp)
+	}
+	if parseKeyword(kw_DEFMETHOD, sexp.headValue()) {
+		return p.parseMethod(sexp)
+	}
 	isDef := parseKeyword(kw_DEF, sexp.headValue())
 	if !isDef {
 		return nil, nil
 	}
 	next := sexp.tailValue()
 	if !next.isCons() {
-		return nil, errors.New("too few arguments to def")
+		return nil, p.errorAt(sexp, "too few arguments to def")
 	}
 	defBlock := next.headValue()
+	if err := p.checkBinder(next); err != nil {
+		return nil, err
+	}
 	if defBlock.isSymbol() {
 		name := defBlock.strValue()
 		next = next.tailValue()
 		if !next.isCons() {
-			return nil, errors.New("too few arguments to def")
+			return nil, p.errorAt(sexp, "too few arguments to def")
 		}
-		value, err := parseExpr(next.headValue())
+		value, err := p.parseElement(next)
 		if err != nil {
 			return nil, err
 		}
 		if !next.tailValue().isEmpty() {
-			return nil, errors.New("too many arguments to def")
+			return nil, p.errorAt(sexp, "too many arguments to def")
 		}
-		return &Def{name, DEF_VALUE, nil, value}, nil
+		return &Def{name: name, typ: DEF_VALUE, body: value, span: p.spanOf(sexp)}, nil
 	}		
 	if defBlock.isCons() {
 		if !defBlock.headValue().isSymbol() { 
-			return nil, errors.New("definition name not a symbol")
+			return nil, p.errorAt(sexp, "definition name not a symbol")
+		}
+		if err := p.checkBinder(defBlock); err != nil {
+			return nil, err
 		}
 		name := defBlock.headValue().strValue()
-		params, err := parseSymbols(defBlock.tailValue())
+		params, err := p.parseParams(defBlock.tailValue())
 		if err != nil {
 			return nil, err
 		}
-		next = next.tailValue()
-		if !next.isCons() {
-			return nil, errors.New("too few arguments to def")
-		}
-		body, err := parseExpr(next.headValue())
+		body, err := p.parseBody("def", sexp, next.tailValue())
 		if err != nil {
 			return nil, err
 		}
-		if !next.tailValue().isEmpty() {
-			return nil, errors.New("too many arguments to def")
-		}
-		return &Def{name, DEF_FUNCTION, params, body}, nil
+		return &Def{name: name, typ: DEF_FUNCTION, params: params, body: body, span: p.spanOf(sexp)}, nil
 	}
-	return nil, errors.New("malformed def")
+	return nil, p.errorAt(sexp, "malformed def")
 }
 
-func parseExpr(sexp Value) (AST, error) {
-	expr := parseAtom(sexp)
+func (p *Parser) parseExpr(sexp Value) (AST, error) {
+	sexp, err := p.expand(sexp)
+	if err != nil {
+		return nil, err
+	}
+	expr := p.parseAtom(sexp, p.spanOf(sexp))
 	if expr != nil {
 		return expr, nil
 	}
-	expr, err := parseQuote(sexp)
+	expr, err = p.parseQuote(sexp)
 	if err != nil || expr != nil {
 		return expr, err
 	}
-	expr, err = parseIf(sexp)
+	expr, err = p.parseQuasiQuote(sexp)
+	if err != nil || expr != nil {
+		return expr, err
+	}
+	expr, err = p.parseIf(sexp)
 	if err != nil  || expr != nil {
 		return expr, err
 	}
-	expr, err = parseFunction(sexp)
+	expr, err = p.parseFunction(sexp)
+	if err != nil || expr != nil {
+		return expr, err
+	}
+	expr, err = p.parseRecFunction(sexp)
+	if err != nil || expr != nil {
+		return expr, err
+	}
+	expr, err = p.parseLet(sexp)
+	if err != nil || expr != nil {
+		return expr, err
+	}
+	expr, err = p.parseLoop(sexp)
+	if err != nil || expr != nil {
+		return expr, err
+	}
+	expr, err = p.parseLetStar(sexp)
+	if err != nil || expr != nil {
+		return expr, err
+	}
+	expr, err = p.parseLetRec(sexp)
+	if err != nil || expr != nil {
+		return expr, err
+	}
+	expr, err = p.parseDo(sexp)
+	if err != nil || expr != nil {
+		return expr, err
+	}
+	expr, err = p.parseAndOr(sexp)
+	if err != nil || expr != nil {
+		return expr, err
+	}
+	expr, err = p.parseCond(sexp)
+	if err != nil || expr != nil {
+		return expr, err
+	}
+	expr, err = p.parseWhen(sexp)
+	if err != nil || expr != nil {
+		return expr, err
+	}
+	expr, err = p.parseCase(sexp)
+	if err != nil || expr != nil {
+		return expr, err
+	}
+	expr, err = p.parseMatch(sexp)
 	if err != nil || expr != nil {
 		return expr, err
 	}
-	expr, err = parseLet(sexp)
+	expr, err = p.parseSet(sexp)
 	if err != nil || expr != nil {
 		return expr, err
 	}
-	expr, err = parseLetStar(sexp)
+	expr, err = p.parseField(sexp)
 	if err != nil || expr != nil {
 		return expr, err
 	}
-	expr, err = parseLetRec(sexp)
+	expr, err = p.parseRaise(sexp)
 	if err != nil || expr != nil {
 		return expr, err
 	}
-	expr, err = parseDo(sexp)
+	expr, err = p.parseTry(sexp)
 	if err != nil || expr != nil {
 		return expr, err
 	}
-	expr, err = parseApply(sexp)
+	expr, err = p.parseApply(sexp)
 	if err != nil || expr != nil {
 		return expr, err
 	}
 	return nil, nil
 }
 
-func parseAtom(sexp Value) AST {
+func (p *Parser) parseAtom(sexp Value, span *Span) AST {
 	if sexp.isSymbol() {
-		return &Id{sexp.strValue()}
+		return &Id{sexp.strValue(), span}
 	}
-	if sexp.isAtom() {
-		return &Literal{sexp}
+	if !sexp.isCons() && !sexp.isEmpty() {
+		// arrays, dicts, #nil, and primitives read by the reader evaluate to themselves
+		return &Literal{sexp, span}
 	}
 	return nil
 }
 
+// parse the head of a list cell
+
+func (p *Parser) parseElement(cell Value) (AST, error) {
+	if cell.headValue().isSymbol() {
+		return p.parseAtom(cell.headValue(), p.headSpan(cell)), nil
+	}
+	return p.parseExpr(cell.headValue())
+}
+
 func parseKeyword(kw string, sexp Value) bool {
 	if !sexp.isSymbol() {
 		return false
@@ -136,7 +375,7 @@ func parseKeyword(kw string, sexp Value) bool {
 	return (sexp.strValue() == kw)
 }
 
-func parseQuote(sexp Value) (AST, error) {
+func (p *Parser) parseQuote(sexp Value) (AST, error) {
 	if !sexp.isCons() {
 		return nil, nil
 	}
@@ -146,15 +385,15 @@ func parseQuote(sexp Value) (AST, error) {
 	}
 	next := sexp.tailValue()
 	if !next.isCons() {
-		return nil, errors.New("malformed quote")
+		return nil, p.errorAt(sexp, "malformed quote")
 	}
 	if !next.tailValue().isEmpty() {
-		return nil, errors.New("too many arguments to quote")
+		return nil, p.errorAt(sexp, "too many arguments to quote")
 	}
-	return &Quote{next.headValue()}, nil
+	return &Quote{next.headValue(), p.spanOf(sexp)}, nil
 }
 
-func parseIf(sexp Value) (AST, error) {
+func (p *Parser) parseIf(sexp Value) (AST, error) {
 	if !sexp.isCons() {
 		return nil, nil
 	}
@@ -164,35 +403,35 @@ func parseIf(sexp Value) (AST, error) {
 	}
 	next := sexp.tailValue()
 	if !next.isCons() {
-		return nil, errors.New("too few arguments to if")
+		return nil, p.errorAt(sexp, "too few arguments to if")
 	}
-	cnd, err := parseExpr(next.headValue())
+	cnd, err := p.parseElement(next)
 	if err != nil {
 		return nil, err
 	}
 	next = next.tailValue()
 	if !next.isCons() {
-		return nil, errors.New("too few arguments to if")
+		return nil, p.errorAt(sexp, "too few arguments to if")
 	}
-	thn, err := parseExpr(next.headValue())
+	thn, err := p.parseElement(next)
 	if err != nil {
 		return nil, err
 	}
 	next = next.tailValue()
 	if !next.isCons() {
-		return nil, errors.New("too few arguments to if")
+		return nil, p.errorAt(sexp, "too few arguments to if")
 	}
-	els, err := parseExpr(next.headValue())
+	els, err := p.parseElement(next)
 	if err != nil {
 		return nil, err
 	}
 	if !next.tailValue().isEmpty() {
-		return nil, errors.New("too many arguments to if")
+		return nil, p.errorAt(sexp, "too many arguments to if")
 	}
-	return &If{cnd, thn, els}, nil
+	return &If{cnd, thn, els, p.spanOf(sexp)}, nil
 }
 
-func parseFunction(sexp Value) (AST, error) {
+func (p *Parser) parseFunction(sexp Value) (AST, error) {
 	if !sexp.isCons() {
 		return nil, nil
 	}
@@ -202,64 +441,51 @@ func parseFunction(sexp Value) (AST, error) {
 	}
 	next := sexp.tailValue()
 	if !next.isCons() {
-		return nil, errors.New("too few arguments to fun")
+		return nil, p.errorAt(sexp, "too few arguments to fun")
 	}
-	if next.headValue().isSymbol() {
-		// we need to parse as a recursive function
-		// restart from scratch
-		return parseRecFunction(sexp)
-	}
-	params, err := parseSymbols(next.headValue())
+	params, err := p.parseParams(next.headValue())
 	if err != nil {
 		return nil, err
 	}
-	next = next.tailValue()
-	if !next.isCons() {
-		return nil, errors.New("too few arguments to fun")
-	}
-	body, err := parseExpr(next.headValue())
+	body, err := p.parseBody("fun", sexp, next.tailValue())
 	if err != nil {
 		return nil, err
 	}
-	if !next.tailValue().isEmpty() {
-		return nil, errors.New("too many arguments to fun")
-	}
-	return makeFunction(params, body), nil
+	return makeFunction(params, body, p.spanOf(sexp)), nil
 }
 
-func parseRecFunction(sexp Value) (AST, error) {
+func (p *Parser) parseRecFunction(sexp Value) (AST, error) {
 	if !sexp.isCons() {
 		return nil, nil
 	}
-	isFun := parseKeyword(kw_FUN, sexp.headValue())
-	if !isFun {
+	isFunRec := parseKeyword(kw_FUNREC, sexp.headValue())
+	if !isFunRec {
 		return nil, nil
 	}
 	next := sexp.tailValue()
-	if !next.isCons() {
-		return nil, errors.New("too few arguments to fun")
+	if !next.isCons() || !next.headValue().isSymbol() {
+		return nil, p.errorAt(sexp, "funrec expects a name")
 	}
-	recName := next.headValue().strValue()
-	next = next.tailValue()
-	params, err := parseSymbols(next.headValue())
-	if err != nil {
+	if err := p.checkBinder(next); err != nil {
 		return nil, err
 	}
+	recName := next.headValue().strValue()
 	next = next.tailValue()
 	if !next.isCons() {
-		return nil, errors.New("too few arguments to fun")
+		return nil, p.errorAt(sexp, "too few arguments to funrec")
 	}
-	body, err := parseExpr(next.headValue())
+	params, err := p.parseParams(next.headValue())
 	if err != nil {
 		return nil, err
 	}
-	if !next.tailValue().isEmpty() {
-		return nil, errors.New("too many arguments to fun")
+	body, err := p.parseBody("funrec", sexp, next.tailValue())
+	if err != nil {
+		return nil, err
 	}
-	return makeRecFunction(recName, params, body), nil
+	return makeRecFunction(recName, params, body, p.spanOf(sexp)), nil
 }
 
-func parseLet(sexp Value) (AST, error) {
+func (p *Parser) parseLet(sexp Value) (AST, error) {
 	if !sexp.isCons() {
 		return nil, nil
 	}
@@ -269,27 +495,85 @@ func parseLet(sexp Value) (AST, error) {
 	}
 	next := sexp.tailValue()
 	if !next.isCons() {
-		return nil, errors.New("too few arguments to let")
+		return nil, p.errorAt(sexp, "too few arguments to let")
+	}
+	if next.headValue().isSymbol() {
+		// named let
+		return p.parseNamedLet(kw_LET, sexp)
+	}
+	params, bindings, err := p.parseBindings(next.headValue())
+	if err != nil {
+		return nil, err
 	}
-	params, bindings, err := parseBindings(next.headValue())
+	body, err := p.parseBody("let", sexp, next.tailValue())
 	if err != nil {
 		return nil, err
 	}
+	return makeLet(params, bindings, body, p.spanOf(sexp)), nil
+}
+
+func (p *Parser) parseLoop(sexp Value) (AST, error) {
+	if !sexp.isCons() {
+		return nil, nil
+	}
+	isLoop := parseKeyword(kw_LOOP, sexp.headValue())
+	if !isLoop || !isLoopForm(sexp) {
+		// loop is a common loop name in named lets, so
+		// anything else is a call to a function named loop
+		return nil, nil
+	}
+	return p.parseNamedLet(kw_LOOP, sexp)
+}
+
+func isLoopForm(sexp Value) bool {
+	if listLength(sexp) < 4 || !listLast(sexp).isEmpty() {
+		return false
+	}
+	next := sexp.tailValue()
+	if !next.headValue().isSymbol() {
+		return false
+	}
+	bindings := next.tailValue().headValue()
+	for bindings.isCons() {
+		binding := bindings.headValue()
+		if listLength(binding) != 2 || !binding.headValue().isSymbol() {
+			return false
+		}
+		bindings = bindings.tailValue()
+	}
+	return bindings.isEmpty()
+}
+
+// (let name ((x e) ...) body ...) and (loop name ((x e) ...) body ...)
+
+func (p *Parser) parseNamedLet(kw string, sexp Value) (AST, error) {
+	next := sexp.tailValue()
+	if !next.isCons() {
+		return nil, p.errorAt(sexp, "too few arguments to " + kw)
+	}
+	if !next.headValue().isSymbol() {
+		return nil, p.errorAtHead(next, "expected loop name in " + kw)
+	}
+	if err := p.checkBinder(next); err != nil {
+		return nil, err
+	}
+	name := next.headValue().strValue()
 	next = next.tailValue()
 	if !next.isCons() {
-		return nil, errors.New("too few arguments to let")
+		return nil, p.errorAt(sexp, "too few arguments to " + kw)
 	}
-	body, err := parseExpr(next.headValue())
+	params, bindings, err := p.parseBindings(next.headValue())
 	if err != nil {
 		return nil, err
 	}
-	if !next.tailValue().isEmpty() {
-		return nil, errors.New("too many arguments to let")
+	body, err := p.parseBody(kw, sexp, next.tailValue())
+	if err != nil {
+		return nil, err
 	}
-	return makeLet(params, bindings, body), nil
+	return makeLoop(name, params, bindings, body, p.spanOf(sexp)), nil
 }
 
-func parseLetStar(sexp Value) (AST, error) {
+func (p *Parser) parseLetStar(sexp Value) (AST, error) {
 	if !sexp.isCons() {
 		return nil, nil
 	}
@@ -299,27 +583,20 @@ func parseLetStar(sexp Value) (AST, error) {
 	}
 	next := sexp.tailValue()
 	if !next.isCons() {
-		return nil, errors.New("too few arguments to let*")
+		return nil, p.errorAt(sexp, "too few arguments to let*")
 	}
-	params, bindings, err := parseBindings(next.headValue())
+	params, bindings, err := p.parseBindings(next.headValue())
 	if err != nil {
 		return nil, err
 	}
-	next = next.tailValue()
-	if !next.isCons() {
-		return nil, errors.New("too few arguments to let*")
-	}
-	body, err := parseExpr(next.headValue())
+	body, err := p.parseBody("let*", sexp, next.tailValue())
 	if err != nil {
 		return nil, err
 	}
-	if !next.tailValue().isEmpty() {
-		return nil, errors.New("too many arguments to let*")
-	}
-	return makeLetStar(params, bindings, body), nil
+	return makeLetStar(params, bindings, body, p.spanOf(sexp)), nil
 }
 
-func parseLetRec(sexp Value) (AST, error) {
+func (p *Parser) parseLetRec(sexp Value) (AST, error) {
 	if !sexp.isCons() {
 		return nil, nil
 	}
@@ -329,45 +606,41 @@ func parseLetRec(sexp Value) (AST, error) {
 	}
 	next := sexp.tailValue()
 	if !next.isCons() {
-		return nil, errors.New("too few arguments to letrec")
+		return nil, p.errorAt(sexp, "too few arguments to letrec")
 	}
-	names, params, bodies, err := parseFunBindings(next.headValue())
+	names, params, bodies, err := p.parseFunBindings(next.headValue())
 	if err != nil {
 		return nil, err
 	}
-	next = next.tailValue()
-	if !next.isCons() {
-		return nil, errors.New("too few arguments to letrec")
-	}
-	body, err := parseExpr(next.headValue())
+	body, err := p.parseBody("letrec", sexp, next.tailValue())
 	if err != nil {
 		return nil, err
 	}
-	if !next.tailValue().isEmpty() {
-		return nil, errors.New("too many arguments to letrec")
-	}
-	return &LetRec{names, params, bodies, body}, nil
+	return &LetRec{names, params, bodies, body, p.spanOf(sexp)}, nil
 }
 
-func parseBindings(sexp Value) ([]string, []AST, error) {
+func (p *Parser) parseBindings(sexp Value) ([]string, []AST, error) {
 	params := make([]string, 0)
 	bindings := make([]AST, 0)
 	current := sexp
 	for current.isCons() {
 		if !current.headValue().isCons() {
-			return nil, nil, errors.New("expected binding (name expr)")
+			return nil, nil, p.errorAtHead(current, "expected binding (name expr)")
 		}
 		if !current.headValue().headValue().isSymbol() {
-			return nil, nil, errors.New("expected name in binding")
+			return nil, nil, p.errorAtHead(current, "expected name in binding")
+		}
+		if err := p.checkBinder(current.headValue()); err != nil {
+			return nil, nil, err
 		}
 		params = append(params, current.headValue().headValue().strValue())
 		if !current.headValue().tailValue().isCons() {
-			return nil, nil, errors.New("expected expr in binding")
+			return nil, nil, p.errorAtHead(current, "expected expr in binding")
 		}
 		if !current.headValue().tailValue().tailValue().isEmpty() {
-			return nil, nil, errors.New("too many elements in binding")
+			return nil, nil, p.errorAtHead(current, "too many elements in binding")
 		}
-		binding, err := parseExpr(current.headValue().tailValue().headValue())
+		binding, err := p.parseElement(current.headValue().tailValue())
 		if err != nil {
 			return nil, nil, err
 		}
@@ -375,39 +648,53 @@ func parseBindings(sexp Value) ([]string, []AST, error) {
 		current = current.tailValue()
 	}
 	if !current.isEmpty() {
-		return nil, nil, errors.New("malformed binding list")
+		return nil, nil, p.errorAt(sexp, "malformed binding list")
 	}
 	return params, bindings, nil
 }
 
-func parseFunBindings(sexp Value) ([]string, [][]string, []AST, error) {
+func (p *Parser) parseFunBindings(sexp Value) ([]string, []*Params, []AST, error) {
 	names := make([]string, 0)
-	params := make([][]string, 0)
+	params := make([]*Params, 0)
 	bodies := make([]AST, 0)
 	current := sexp
 	for current.isCons() {
 		if !current.headValue().isCons() {
-			return nil, nil, nil, errors.New("expected binding (name params expr)")
+			return nil, nil, nil, p.errorAtHead(current, "expected binding (name expr) or (name params expr)")
 		}
 		if !current.headValue().headValue().isSymbol() {
-			return nil, nil, nil, errors.New("expected name in binding")
+			return nil, nil, nil, p.errorAtHead(current, "expected name in binding")
+		}
+		if err := p.checkBinder(current.headValue()); err != nil {
+			return nil, nil, nil, err
 		}
 		names = append(names, current.headValue().headValue().strValue())
 		if !current.headValue().tailValue().isCons() {
-			return nil, nil, nil, errors.New("expected params in binding")
+			return nil, nil, nil, p.errorAtHead(current, "expected params in binding")
 		}
-		these_params, err := parseSymbols(current.headValue().tailValue().headValue())
+		if current.headValue().tailValue().tailValue().isEmpty() {
+			// (name expr) binds a value
+			value, err := p.parseElement(current.headValue().tailValue())
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			params = append(params, nil)
+			bodies = append(bodies, value)
+			current = current.tailValue()
+			continue
+		}
+		these_params, err := p.parseParams(current.headValue().tailValue().headValue())
 		if err != nil {
 			return nil, nil, nil, err
 		}
 		params = append(params, these_params)
 		if !current.headValue().tailValue().tailValue().isCons() {
-			return nil, nil, nil, errors.New("expected expr in binding")
+			return nil, nil, nil, p.errorAtHead(current, "expected expr in binding")
 		}
 		if !current.headValue().tailValue().tailValue().tailValue().isEmpty() {
-			return nil, nil, nil, errors.New("too many elements in binding")
+			return nil, nil, nil, p.errorAtHead(current, "too many elements in binding")
 		}
-		body, err := parseExpr(current.headValue().tailValue().tailValue().headValue())
+		body, err := p.parseElement(current.headValue().tailValue().tailValue())
 		if err != nil {
 			return nil, nil, nil, err
 		}
@@ -415,55 +702,117 @@ func parseFunBindings(sexp Value) ([]string, [][]string, []AST, error) {
 		current = current.tailValue()
 	}
 	if !current.isEmpty() {
-		return nil, nil, nil, errors.New("malformed binding list")
+		return nil, nil, nil, p.errorAt(sexp, "malformed binding list")
 	}
 	return names, params, bodies, nil
 }
 
-func makeLet(params []string, bindings []AST, body AST) AST {
-	return &Apply{makeFunction(params, body), bindings}
+func makeLet(params []string, bindings []AST, body AST, span *Span) AST {
+	return &Apply{makeFunction(simpleParams(params), body, span), bindings, span}
 }
 
-func makeLetStar(params []string, bindings []AST, body AST) AST {
+func makeLetStar(params []string, bindings []AST, body AST, span *Span) AST {
 	result := body
 	for i := len(params) - 1; i >= 0; i-- {
-		result = makeLet([]string{params[i]}, []AST{bindings[i]}, result)
+		result = makeLet([]string{params[i]}, []AST{bindings[i]}, result, span)
 	}
 	return result
 }
 
-func makeFunction(params []string, body AST) AST {
+func makeFunction(params *Params, body AST, span *Span) AST {
 	name := fresh("__temp")
-	return &LetRec{[]string{name}, [][]string{params}, []AST{body}, &Id{name}}
+	return &LetRec{[]string{name}, []*Params{params}, []AST{body}, &Id{name, span}, span}
+}
+
+// the initial bindings are evaluated outside the scope of the loop name
+
+func makeLoop(name string, params []string, bindings []AST, body AST, span *Span) AST {
+	return &Apply{makeRecFunction(name, simpleParams(params), body, span), bindings, span}
 }
 
-func makeRecFunction(recName string, params []string, body AST) AST {
-	return &LetRec{[]string{recName}, [][]string{params}, []AST{body}, &Id{recName}}
+func makeRecFunction(recName string, params *Params, body AST, span *Span) AST {
+	return &LetRec{[]string{recName}, []*Params{params}, []AST{body}, &Id{recName, span}, span}
+}
+
+// a body is one or more expressions evaluated in order, possibly
+// preceded by internal definitions which are in scope in the whole
+// body, like in a letrec
+
+func (p *Parser) parseBody(kw string, sexp Value, forms Value) (AST, error) {
+	if !forms.isCons() {
+		return nil, p.errorAt(sexp, "too few arguments to " + kw)
+	}
+	if !listLast(forms).isEmpty() {
+		return nil, p.errorAt(sexp, "malformed " + kw)
+	}
+	names := make([]string, 0)
+	params := make([]*Params, 0)
+	values := make([]AST, 0)
+	exprs := make([]AST, 0)
+	for current := forms; current.isCons(); current = current.tailValue() {
+		form, err := p.expand(current.headValue())
+		if err != nil {
+			return nil, err
+		}
+		if form.isCons() && parseKeyword(kw_DEF, form.headValue()) {
+			if len(exprs) > 0 {
+				return nil, p.errorAt(form, "definition after expressions in " + kw)
+			}
+			d, err := p.parseDef(form)
+			if err != nil {
+				return nil, err
+			}
+			names = append(names, d.name)
+			params = append(params, d.params)
+			values = append(values, d.body)
+			continue
+		}
+		if form.isSymbol() {
+			exprs = append(exprs, p.parseAtom(form, p.headSpan(current)))
+			continue
+		}
+		expr, err := p.parseExpr(form)
+		if err != nil {
+			return nil, err
+		}
+		if expr == nil {
+			return nil, p.errorAt(form, "cannot parse expression in " + kw)
+		}
+		exprs = append(exprs, expr)
+	}
+	if len(exprs) == 0 {
+		return nil, p.errorAt(sexp, "no expression after definitions in " + kw)
+	}
+	body := makeDo(exprs, p.spanOf(sexp))
+	if len(names) == 0 {
+		return body, nil
+	}
+	return &LetRec{names, params, values, body, p.spanOf(sexp)}, nil
 }
 
-func parseApply(sexp Value) (AST, error) {
+func (p *Parser) parseApply(sexp Value) (AST, error) {
 	if !sexp.isCons() {
 		return nil, nil
 	}
-	fun, err := parseExpr(sexp.headValue())
+	fun, err := p.parseElement(sexp)
 	if err != nil {
 		return nil, err
 	}
 	if fun == nil {
 		return nil, nil
 	}
-	args, err := parseExprs(sexp.tailValue())
+	args, err := p.parseExprs(sexp.tailValue())
 	if err != nil {
 		return nil, err
 	}
-	return &Apply{fun, args}, nil
+	return &Apply{fun, args, p.spanOf(sexp)}, nil
 }
 
-func parseExprs(sexp Value) ([]AST, error) {
+func (p *Parser) parseExprs(sexp Value) ([]AST, error) {
 	args := make([]AST, 0)
 	current := sexp
 	for current.isCons() {
-		next, err := parseExpr(current.headValue())
+		next, err := p.parseElement(current)
 		if err != nil {
 			return nil, err
 		}
@@ -474,28 +823,58 @@ func parseExprs(sexp Value) ([]AST, error) {
 		current = current.tailValue()
 	}
 	if !current.isEmpty() {
-		return nil, errors.New("malformed expression list")
+		return nil, p.errorAt(sexp, "malformed expression list")
 	}
 	return args, nil
 }
 
-func parseSymbols(sexp Value) ([]string, error) {
-	params := make([]string, 0)
+// parameters are (a b (c default) . rest), or just a symbol for
+// a function taking any number of arguments
+
+func (p *Parser) parseParams(sexp Value) (*Params, error) {
+	params := simpleParams([]string{})
 	current := sexp
 	for current.isCons() {
-		if !current.headValue().isSymbol() {
-			return nil, errors.New("expected symbol in list")
+		param := current.headValue()
+		if err := p.checkBinder(current); err != nil {
+			return nil, err
+		}
+		if param.isCons() {
+			if err := p.checkBinder(param); err != nil {
+				return nil, err
+			}
+		}
+		if param.isSymbol() {
+			if len(params.optional) > 0 {
+				return nil, p.errorAtHead(current, "required parameter after optional parameter")
+			}
+			params.required = append(params.required, param.strValue())
+		} else if param.isCons() && param.headValue().isSymbol() && param.tailValue().isCons() && param.tailValue().tailValue().isEmpty() {
+			def, err := p.parseElement(param.tailValue())
+			if err != nil {
+				return nil, err
+			}
+			params.optional = append(params.optional, param.headValue().strValue())
+			params.defaults = append(params.defaults, def)
+		} else {
+			return nil, p.errorAt(param, "expected symbol in list")
 		}
-		params = append(params, current.headValue().strValue())
 		current = current.tailValue()
 	}
+	if current.isSymbol() {
+		if isKeywordName(current.strValue()) {
+			return nil, p.errorAt(sexp, "cannot bind keyword " + current.strValue())
+		}
+		params.rest = current.strValue()
+		return params, nil
+	}
 	if !current.isEmpty() {
-		return nil, errors.New("malformed symbol list")
+		return nil, p.errorAt(sexp, "malformed symbol list")
 	}
 	return params, nil
 }
 
-func parseDo(sexp Value) (AST, error) {
+func (p *Parser) parseDo(sexp Value) (AST, error) {
 	if !sexp.isCons() {
 		return nil, nil
 	}
@@ -503,20 +882,1026 @@ func parseDo(sexp Value) (AST, error) {
 	if !isDo {
 		return nil, nil
 	}
-	exprs, err := parseExprs(sexp.tailValue())
+	exprs, err := p.parseExprs(sexp.tailValue())
 	if err != nil {
 		return nil, err
 	}
-	return makeDo(exprs), nil
+	return makeDo(exprs, p.spanOf(sexp)), nil
 }
 
-func makeDo(exprs []AST) AST {
+func makeDo(exprs []AST, span *Span) AST {
 	if len(exprs) > 0 {
 		result := exprs[len(exprs) - 1]
 		for i := len(exprs) - 2; i >= 0; i-- {
-			result = makeLet([]string{fresh("__temp")}, []AST{exprs[i]}, result)
+			result = makeLet([]string{fresh("__temp")}, []AST{exprs[i]}, result, span)
 		}
 		return result
 	}
-	return &Literal{&VNil{}}
+	return &Literal{&VNil{}, span}
+}
+
+func (p *Parser) parseSet(sexp Value) (AST, error) {
+	if !sexp.isCons() {
+		return nil, nil
+	}
+	isSet := parseKeyword(kw_SET, sexp.headValue())
+	if !isSet {
+		return nil, nil
+	}
+	next := sexp.tailValue()
+	if !next.isCons() || !next.tailValue().isCons() {
+		return nil, p.errorAt(sexp, "too few arguments to set!")
+	}
+	if !next.headValue().isSymbol() {
+		return nil, p.errorAtHead(next, "set! target not a symbol")
+	}
+	exp, err := p.parseElement(next.tailValue())
+	if err != nil {
+		return nil, err
+	}
+	if !next.tailValue().tailValue().isEmpty() {
+		return nil, p.errorAt(sexp, "too many arguments to set!")
+	}
+	return &Set{next.headValue().strValue(), exp, p.spanOf(sexp)}, nil
+}
+
+func (p *Parser) parseRaise(sexp Value) (AST, error) {
+	if !sexp.isCons() {
+		return nil, nil
+	}
+	isRaise := parseKeyword(kw_RAISE, sexp.headValue())
+	if !isRaise {
+		return nil, nil
+	}
+	next := sexp.tailValue()
+	if !next.isCons() {
+		return nil, p.errorAt(sexp, "too few arguments to raise")
+	}
+	exp, err := p.parseElement(next)
+	if err != nil {
+		return nil, err
+	}
+	if !next.tailValue().isEmpty() {
+		return nil, p.errorAt(sexp, "too many arguments to raise")
+	}
+	return &Raise{exp, p.spanOf(sexp)}, nil
+}
+
+// (try expr
+//   (catch kind name handler)      -- kind is an error kind or _ for any
+//   (catch-if pred name handler)   -- pred is applied to the error
+//   (finally expr))
+
+func (p *Parser) parseTry(sexp Value) (AST, error) {
+	if !sexp.isCons() {
+		return nil, nil
+	}
+	isTry := parseKeyword(kw_TRY, sexp.headValue())
+	if !isTry {
+		return nil, nil
+	}
+	// the body is everything up to the first clause
+	forms := []Value{}
+	current := sexp.tailValue()
+	for current.isCons() && !isTryClause(current.headValue()) {
+		forms = append(forms, current.headValue())
+		current = current.tailValue()
+	}
+	var bodyForms Value = &VEmpty{}
+	for i := len(forms) - 1; i >= 0; i-- {
+		bodyForms = &VCons{head: forms[i], tail: bodyForms}
+	}
+	body, err := p.parseBody(kw_TRY, sexp, bodyForms)
+	if err != nil {
+		return nil, err
+	}
+	clauses := make([]*Catch, 0)
+	var finally AST
+	for current.isCons() {
+		clause := current.headValue()
+		if finally != nil {
+			return nil, p.errorAt(clause, "finally must be the last clause of try")
+		}
+		if clause.isCons() && parseKeyword(kw_FINALLY, clause.headValue()) {
+			finally, err = p.parseBody(kw_FINALLY, clause, clause.tailValue())
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			c, err := p.parseCatch(clause)
+			if err != nil {
+				return nil, err
+			}
+			clauses = append(clauses, c)
+		}
+		current = current.tailValue()
+	}
+	if !current.isEmpty() {
+		return nil, p.errorAt(sexp, "malformed try")
+	}
+	return &Try{body, clauses, finally, p.spanOf(sexp)}, nil
+}
+
+func isTryClause(sexp Value) bool {
+	if !sexp.isCons() {
+		return false
+	}
+	head := sexp.headValue()
+	return parseKeyword(kw_CATCH, head) || parseKeyword(kw_CATCHIF, head) || parseKeyword(kw_FINALLY, head)
+}
+
+func (p *Parser) parseCatch(sexp Value) (*Catch, error) {
+	if !sexp.isCons() {
+		return nil, p.errorAt(sexp, "expected catch clause in try")
+	}
+	isCatch := parseKeyword(kw_CATCH, sexp.headValue())
+	isCatchIf := parseKeyword(kw_CATCHIF, sexp.headValue())
+	if !isCatch && !isCatchIf {
+		return nil, p.errorAt(sexp, "expected catch clause in try")
+	}
+	next := sexp.tailValue()
+	if listLength(next) < 3 || !listLast(next).isEmpty() {
+		return nil, p.errorAt(sexp, "malformed catch clause")
+	}
+	selector := next.headValue()
+	if !next.tailValue().headValue().isSymbol() {
+		return nil, p.errorAt(sexp, "expected name in catch clause")
+	}
+	name := next.tailValue().headValue().strValue()
+	handler, err := p.parseBody(sexp.headValue().strValue(), sexp, next.tailValue().tailValue())
+	if err != nil {
+		return nil, err
+	}
+	if isCatchIf {
+		pred, err := p.parseElement(next)
+		if err != nil {
+			return nil, err
+		}
+		return &Catch{"", pred, name, handler}, nil
+	}
+	if !selector.isSymbol() {
+		return nil, p.errorAtHead(next, "expected error kind in catch clause")
+	}
+	kind := selector.strValue()
+	if kind != "_" && !isErrorKind(kind) {
+		return nil, p.errorAtHead(next, fmt.Sprintf("unknown error kind %s", kind))
+	}
+	return &Catch{kind, nil, name, handler}, nil
+}
+
+// (const name expr)  or  (var name expr)
+
+func (p *Parser) parseDeclaration(kw string, typ int, sexp Value) (*Def, error) {
+	next := sexp.tailValue()
+	if !next.isCons() || !next.tailValue().isCons() {
+		return nil, p.errorAt(sexp, "too few arguments to " + kw)
+	}
+	if !next.headValue().isSymbol() {
+		return nil, p.errorAt(sexp, kw + " name not a symbol")
+	}
+	if err := p.checkBinder(next); err != nil {
+		return nil, err
+	}
+	name := next.headValue().strValue()
+	value, err := p.parseElement(next.tailValue())
+	if err != nil {
+		return nil, err
+	}
+	if !next.tailValue().tailValue().isEmpty() {
+		return nil, p.errorAt(sexp, "too many arguments to " + kw)
+	}
+	return &Def{name: name, typ: typ, body: value, span: p.spanOf(sexp)}, nil
+}
+
+// (deftype name field ...)  or  (deftype name (variant field ...) ...)
+
+func (p *Parser) parseDefType(sexp Value) (*Def, error) {
+	next := sexp.tailValue()
+	if !next.isCons() || !next.headValue().isSymbol() {
+		return nil, p.errorAt(sexp, "expected type name in deftype")
+	}
+	name := next.headValue().strValue()
+	rtype := &RecordType{name, []*Variant{}}
+	specs := next.tailValue()
+	if !listLast(specs).isEmpty() {
+		return nil, p.errorAt(sexp, "malformed deftype")
+	}
+	if !specs.isCons() || specs.headValue().isSymbol() {
+		// a product type
+		fields, err := p.parseFields(sexp, specs)
+		if err != nil {
+			return nil, err
+		}
+		rtype.variants = append(rtype.variants, &Variant{name, fields, rtype})
+		return &Def{name: name, typ: DEF_TYPE, span: p.spanOf(sexp), rtype: rtype}, nil
+	}
+	for current := specs; current.isCons(); current = current.tailValue() {
+		spec := current.headValue()
+		if !spec.isCons() || !spec.headValue().isSymbol() {
+			return nil, p.errorAt(spec, "expected variant (name field ...) in deftype")
+		}
+		vname := spec.headValue().strValue()
+		if vname == name {
+			return nil, p.errorAt(spec, "variant " + vname + " has the name of its type")
+		}
+		for _, other := range rtype.variants {
+			if other.name == vname {
+				return nil, p.errorAt(spec, "variant " + vname + " appears twice in deftype")
+			}
+		}
+		fields, err := p.parseFields(spec, spec.tailValue())
+		if err != nil {
+			return nil, err
+		}
+		rtype.variants = append(rtype.variants, &Variant{vname, fields, rtype})
+	}
+	return &Def{name: name, typ: DEF_TYPE, span: p.spanOf(sexp), rtype: rtype}, nil
+}
+
+func (p *Parser) parseFields(sexp Value, fields Value) ([]string, error) {
+	result := make([]string, 0)
+	for current := fields; current.isCons(); current = current.tailValue() {
+		field := current.headValue()
+		if !field.isSymbol() {
+			return nil, p.errorAt(field, "field name not a symbol")
+		}
+		for _, other := range result {
+			if other == field.strValue() {
+				return nil, p.errorAtHead(current, "field " + other + " appears twice")
+			}
+		}
+		result = append(result, field.strValue())
+	}
+	if !listLast(fields).isEmpty() {
+		return nil, p.errorAt(sexp, "malformed field list")
+	}
+	return result, nil
+}
+
+// (class name (params ...) (field name expr) ... (def (name params ...) body) ...)
+
+func (p *Parser) parseClass(sexp Value) (*Def, error) {
+	next := sexp.tailValue()
+	if !next.isCons() || !next.headValue().isSymbol() {
+		return nil, p.errorAt(sexp, "expected class name in class")
+	}
+	name := next.headValue().strValue()
+	next = next.tailValue()
+	if !next.isCons() {
+		return nil, p.errorAt(sexp, "expected parameters in class")
+	}
+	params, err := p.parseParams(next.headValue())
+	if err != nil {
+		return nil, err
+	}
+	if !listLast(next).isEmpty() {
+		return nil, p.errorAt(sexp, "malformed class")
+	}
+	class := &Class{name, params, []string{}, []AST{}, []string{}, []*Params{}, []AST{}}
+	seen := map[string]bool{}
+	for current := next.tailValue(); current.isCons(); current = current.tailValue() {
+		member := current.headValue()
+		if !member.isCons() || !member.tailValue().isCons() {
+			return nil, p.errorAt(member, "expected field or def in class")
+		}
+		if parseKeyword(kw_FIELD, member.headValue()) {
+			spec := member.tailValue()
+			if !spec.headValue().isSymbol() {
+				return nil, p.errorAt(member, "field name not a symbol")
+			}
+			if !spec.tailValue().isCons() {
+				return nil, p.errorAt(member, "too few arguments to field")
+			}
+			if !spec.tailValue().tailValue().isEmpty() {
+				return nil, p.errorAt(member, "too many arguments to field")
+			}
+			field := spec.headValue().strValue()
+			if seen[field] {
+				return nil, p.errorAt(member, "member " + field + " appears twice in class")
+			}
+			seen[field] = true
+			init, err := p.parseElement(spec.tailValue())
+			if err != nil {
+				return nil, err
+			}
+			class.fields = append(class.fields, field)
+			class.inits = append(class.inits, init)
+			continue
+		}
+		if parseKeyword(kw_DEF, member.headValue()) {
+			defBlock := member.tailValue().headValue()
+			if !defBlock.isCons() || !defBlock.headValue().isSymbol() {
+				return nil, p.errorAt(member, "expected method (name params ...) in class")
+			}
+			method := defBlock.headValue().strValue()
+			if seen[method] {
+				return nil, p.errorAt(member, "member " + method + " appears twice in class")
+			}
+			seen[method] = true
+			methodParams, err := p.parseParams(defBlock.tailValue())
+			if err != nil {
+				return nil, err
+			}
+			body, err := p.parseBody("def", member, member.tailValue().tailValue())
+			if err != nil {
+				return nil, err
+			}
+			class.methods = append(class.methods, method)
+			class.methodParams = append(class.methodParams, methodParams)
+			class.methodBodies = append(class.methodBodies, body)
+			continue
+		}
+		return nil, p.errorAt(member, "expected field or def in class")
+	}
+	return &Def{name: name, typ: DEF_CLASS, span: p.spanOf(sexp), class: class}, nil
+}
+
+// (defgeneric (name params ...) body)
+// the body is the default method, and can be omitted
+
+func (p *Parser) parseGeneric(sexp Value) (*Def, error) {
+	next := sexp.tailValue()
+	if !next.isCons() {
+		return nil, p.errorAt(sexp, "too few arguments to defgeneric")
+	}
+	defBlock := next.headValue()
+	if !defBlock.isCons() || !defBlock.headValue().isSymbol() {
+		return nil, p.errorAt(sexp, "malformed defgeneric")
+	}
+	name := defBlock.headValue().strValue()
+	params, err := p.parseParams(defBlock.tailValue())
+	if err != nil {
+		return nil, err
+	}
+	var body AST
+	if !next.tailValue().isEmpty() {
+		body, err = p.parseBody("defgeneric", sexp, next.tailValue())
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &Def{name: name, typ: DEF_GENERIC, params: params, body: body, span: p.spanOf(sexp)}, nil
+}
+
+// (defmethod (name param ... . rest) body)
+// where a param (name type) only accepts arguments of that type
+
+func (p *Parser) parseMethod(sexp Value) (*Def, error) {
+	next := sexp.tailValue()
+	if !next.isCons() {
+		return nil, p.errorAt(sexp, "too few arguments to defmethod")
+	}
+	defBlock := next.headValue()
+	if !defBlock.isCons() || !defBlock.headValue().isSymbol() {
+		return nil, p.errorAt(sexp, "malformed defmethod")
+	}
+	generic := defBlock.headValue().strValue()
+	names := []string{}
+	specs := []string{}
+	current := defBlock.tailValue()
+	for current.isCons() {
+		param := current.headValue()
+		if param.isSymbol() {
+			names = append(names, param.strValue())
+			specs = append(specs, anyType)
+		} else if param.isCons() && param.headValue().isSymbol() && param.tailValue().isCons() && param.tailValue().headValue().isSymbol() && param.tailValue().tailValue().isEmpty() {
+			names = append(names, param.headValue().strValue())
+			specs = append(specs, param.tailValue().headValue().strValue())
+		} else {
+			return nil, p.errorAt(param, "expected parameter name or (name type) in defmethod")
+		}
+		current = current.tailValue()
+	}
+	params := simpleParams(names)
+	if current.isSymbol() {
+		params.rest = current.strValue()
+	} else if !current.isEmpty() {
+		return nil, p.errorAt(sexp, "malformed defmethod")
+	}
+	body, err := p.parseBody("defmethod", sexp, next.tailValue())
+	if err != nil {
+		return nil, err
+	}
+	method := &MethodDef{generic, specs}
+	return &Def{name: methodKey(generic, specs), typ: DEF_METHOD, params: params, body: body, span: p.spanOf(sexp), method: method}, nil
+}
+
+// (field-get obj name) and (field-set obj name expr)
+
+func (p *Parser) parseField(sexp Value) (AST, error) {
+	if !sexp.isCons() {
+		return nil, nil
+	}
+	kw := ""
+	var fn Value
+	if parseKeyword(kw_FIELD_GET, sexp.headValue()) {
+		kw, fn = kw_FIELD_GET, fieldGet
+	} else if parseKeyword(kw_FIELD_SET, sexp.headValue()) {
+		kw, fn = kw_FIELD_SET, fieldSet
+	} else {
+		return nil, nil
+	}
+	if !listLast(sexp).isEmpty() {
+		return nil, p.errorAt(sexp, "malformed " + kw)
+	}
+	args := []Value{}
+	cells := []Value{}
+	for current := sexp.tailValue(); current.isCons(); current = current.tailValue() {
+		args = append(args, current.headValue())
+		cells = append(cells, current)
+	}
+	count := 2
+	if kw == kw_FIELD_SET {
+		count = 3
+	}
+	if len(args) < count {
+		return nil, p.errorAt(sexp, "too few arguments to " + kw)
+	}
+	if len(args) > count {
+		return nil, p.errorAt(sexp, "too many arguments to " + kw)
+	}
+	if !args[1].isSymbol() {
+		return nil, p.errorAtHead(cells[1], "field name not a symbol")
+	}
+	span := p.spanOf(sexp)
+	obj, err := p.parseElement(cells[0])
+	if err != nil {
+		return nil, err
+	}
+	exprs := []AST{obj, &Quote{args[1], span}}
+	if kw == kw_FIELD_SET {
+		value, err := p.parseElement(cells[2])
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, value)
+	}
+	return &Apply{&Literal{fn, span}, exprs, span}, nil
+}
+
+// (macro (name params ...) body)
+// body computes the expansion from the unevaluated arguments
+
+func (p *Parser) parseMacro(sexp Value) (*Def, error) {
+	next := sexp.tailValue()
+	if !next.isCons() {
+		return nil, p.errorAt(sexp, "too few arguments to macro")
+	}
+	defBlock := next.headValue()
+	if !defBlock.isCons() || !defBlock.headValue().isSymbol() {
+		return nil, p.errorAt(sexp, "malformed macro")
+	}
+	name := defBlock.headValue().strValue()
+	params, err := p.parseParams(defBlock.tailValue())
+	if err != nil {
+		return nil, err
+	}
+	body, err := p.parseBody("macro", sexp, next.tailValue())
+	if err != nil {
+		return nil, err
+	}
+	return &Def{name: name, typ: DEF_MACRO, params: params, body: body, span: p.spanOf(sexp)}, nil
+}
+
+// quasiquote is compiled into applications of these primitives
+// directly, so that it does not depend on what cons or append
+// happen to be bound to
+
+var qqCons = &VPrimitive{"cons", func(args []Value) (Value, error) {
+	return &VCons{head: args[0], tail: args[1]}, nil
+}}
+
+var qqAppend = &VPrimitive{"append", func(args []Value) (Value, error) {
+	if !isList(args[0]) || !listLast(args[0]).isEmpty() {
+		return nil, newError(ERR_TYPE, args[0], "unquote-splicing - not a list %s", args[0].display())
+	}
+	return listAppend(args[0], args[1]), nil
+}}
+
+func (p *Parser) parseQuasiQuote(sexp Value) (AST, error) {
+	if !sexp.isCons() {
+		return nil, nil
+	}
+	isQQ := parseKeyword(kw_QUASIQUOTE, sexp.headValue())
+	if !isQQ {
+		return nil, nil
+	}
+	next := sexp.tailValue()
+	if !next.isCons() {
+		return nil, p.errorAt(sexp, "malformed quasiquote")
+	}
+	if !next.tailValue().isEmpty() {
+		return nil, p.errorAt(sexp, "too many arguments to quasiquote")
+	}
+	return p.parseQQ(next.headValue(), 1)
+}
+
+// is sexp (kw x)?
+func isTagged(kw string, sexp Value) bool {
+	return sexp.isCons() && parseKeyword(kw, sexp.headValue()) && sexp.tailValue().isCons() && sexp.tailValue().tailValue().isEmpty()
+}
+
+func (p *Parser) parseQQ(sexp Value, depth int) (AST, error) {
+	span := p.spanOf(sexp)
+	if isTagged(kw_UNQUOTE, sexp) {
+		if depth == 1 {
+			return p.parseElement(sexp.tailValue())
+		}
+		return p.parseQQTagged(kw_UNQUOTE, sexp, depth - 1)
+	}
+	if isTagged(kw_QUASIQUOTE, sexp) {
+		return p.parseQQTagged(kw_QUASIQUOTE, sexp, depth + 1)
+	}
+	if isTagged(kw_UNQUOTESPLICING, sexp) {
+		return nil, p.errorAt(sexp, "unquote-splicing not in a list")
+	}
+	if !sexp.isCons() {
+		return &Quote{sexp, span}, nil
+	}
+	head := sexp.headValue()
+	// `(a . ,b) reads as (a unquote b)
+	tail, err := p.parseQQ(sexp.tailValue(), depth)
+	if err != nil {
+		return nil, err
+	}
+	if isTagged(kw_UNQUOTESPLICING, head) && depth == 1 {
+		spliced, err := p.parseElement(head.tailValue())
+		if err != nil {
+			return nil, err
+		}
+		return &Apply{&Literal{qqAppend, span}, []AST{spliced, tail}, span}, nil
+	}
+	first, err := p.parseQQ(head, depth)
+	if err != nil {
+		return nil, err
+	}
+	return &Apply{&Literal{qqCons, span}, []AST{first, tail}, span}, nil
+}
+
+func (p *Parser) parseQQTagged(kw string, sexp Value, depth int) (AST, error) {
+	span := p.spanOf(sexp)
+	inner, err := p.parseQQ(sexp.tailValue().headValue(), depth)
+	if err != nil {
+		return nil, err
+	}
+	list := &Apply{&Literal{qqCons, span}, []AST{inner, &Quote{&VEmpty{}, span}}, span}
+	return &Apply{&Literal{qqCons, span}, []AST{&Quote{intern(kw), span}, list}, span}, nil
+}
+
+func (p *Parser) parseAndOr(sexp Value) (AST, error) {
+	if !sexp.isCons() {
+		return nil, nil
+	}
+	isAnd := parseKeyword(kw_AND, sexp.headValue())
+	isOr := parseKeyword(kw_OR, sexp.headValue())
+	if !isAnd && !isOr {
+		return nil, nil
+	}
+	exprs, err := p.parseExprs(sexp.tailValue())
+	if err != nil {
+		return nil, err
+	}
+	if isAnd {
+		return &And{exprs, p.spanOf(sexp)}, nil
+	}
+	return &Or{exprs, p.spanOf(sexp)}, nil
+}
+
+// (cond (test expr ...) ... (else expr ...))
+
+func (p *Parser) parseCond(sexp Value) (AST, error) {
+	if !sexp.isCons() {
+		return nil, nil
+	}
+	isCond := parseKeyword(kw_COND, sexp.headValue())
+	if !isCond {
+		return nil, nil
+	}
+	tests := make([]AST, 0)
+	bodies := make([]AST, 0)
+	var els AST
+	current := sexp.tailValue()
+	for current.isCons() {
+		clause := current.headValue()
+		if els != nil {
+			return nil, p.errorAt(clause, "else must be the last clause of cond")
+		}
+		if !clause.isCons() || !clause.tailValue().isCons() {
+			return nil, p.errorAt(clause, "expected clause (test expr ...) in cond")
+		}
+		body, err := p.parseBody("cond", clause, clause.tailValue())
+		if err != nil {
+			return nil, err
+		}
+		if parseKeyword(kw_ELSE, clause.headValue()) {
+			els = body
+		} else {
+			test, err := p.parseElement(clause)
+			if err != nil {
+				return nil, err
+			}
+			tests = append(tests, test)
+			bodies = append(bodies, body)
+		}
+		current = current.tailValue()
+	}
+	if !current.isEmpty() {
+		return nil, p.errorAt(sexp, "malformed cond")
+	}
+	return &Cond{tests, bodies, els, p.spanOf(sexp)}, nil
+}
+
+// (when test expr ...) and (unless test expr ...) are one-clause conds
+
+func (p *Parser) parseWhen(sexp Value) (AST, error) {
+	if !sexp.isCons() {
+		return nil, nil
+	}
+	isWhen := parseKeyword(kw_WHEN, sexp.headValue())
+	isUnless := parseKeyword(kw_UNLESS, sexp.headValue())
+	if !isWhen && !isUnless {
+		return nil, nil
+	}
+	kw := sexp.headValue().strValue()
+	next := sexp.tailValue()
+	if !next.isCons() {
+		return nil, p.errorAt(sexp, "too few arguments to " + kw)
+	}
+	test, err := p.parseElement(next)
+	if err != nil {
+		return nil, err
+	}
+	body, err := p.parseBody(kw, sexp, next.tailValue())
+	if err != nil {
+		return nil, err
+	}
+	span := p.spanOf(sexp)
+	if isWhen {
+		return &Cond{[]AST{test}, []AST{body}, nil, span}, nil
+	}
+	return &Cond{[]AST{test}, []AST{&Literal{&VNil{}, span}}, body, span}, nil
+}
+
+// (case expr ((value ...) expr ...) ... (else expr ...))
+// values are not evaluated
+
+func (p *Parser) parseCase(sexp Value) (AST, error) {
+	if !sexp.isCons() {
+		return nil, nil
+	}
+	isCase := parseKeyword(kw_CASE, sexp.headValue())
+	if !isCase {
+		return nil, nil
+	}
+	next := sexp.tailValue()
+	if !next.isCons() {
+		return nil, p.errorAt(sexp, "too few arguments to case")
+	}
+	key, err := p.parseElement(next)
+	if err != nil {
+		return nil, err
+	}
+	values := make([][]Value, 0)
+	bodies := make([]AST, 0)
+	var els AST
+	current := next.tailValue()
+	for current.isCons() {
+		clause := current.headValue()
+		if els != nil {
+			return nil, p.errorAt(clause, "else must be the last clause of case")
+		}
+		if !clause.isCons() || !clause.tailValue().isCons() {
+			return nil, p.errorAt(clause, "expected clause ((value ...) expr ...) in case")
+		}
+		body, err := p.parseBody("case", clause, clause.tailValue())
+		if err != nil {
+			return nil, err
+		}
+		if parseKeyword(kw_ELSE, clause.headValue()) {
+			els = body
+		} else {
+			these := make([]Value, 0)
+			vs := clause.headValue()
+			for vs.isCons() {
+				these = append(these, vs.headValue())
+				vs = vs.tailValue()
+			}
+			if !vs.isEmpty() {
+				return nil, p.errorAt(clause, "expected list of values in case clause")
+			}
+			values = append(values, these)
+			bodies = append(bodies, body)
+		}
+		current = current.tailValue()
+	}
+	if !current.isEmpty() {
+		return nil, p.errorAt(sexp, "malformed case")
+	}
+	return &Case{key, values, bodies, els, p.spanOf(sexp)}, nil
+}
+
+// (match expr (pattern expr ...) ... (pattern when guard expr ...) ...)
+
+func (p *Parser) parseMatch(sexp Value) (AST, error) {
+	if !sexp.isCons() {
+		return nil, nil
+	}
+	isMatch := parseKeyword(kw_MATCH, sexp.headValue())
+	if !isMatch {
+		return nil, nil
+	}
+	next := sexp.tailValue()
+	if !next.isCons() {
+		return nil, p.errorAt(sexp, "too few arguments to match")
+	}
+	exp, err := p.parseElement(next)
+	if err != nil {
+		return nil, err
+	}
+	clauses := make([]*MatchClause, 0)
+	current := next.tailValue()
+	for current.isCons() {
+		clause, err := p.parseMatchClause(current.headValue())
+		if err != nil {
+			return nil, err
+		}
+		clauses = append(clauses, clause)
+		current = current.tailValue()
+	}
+	if !current.isEmpty() {
+		return nil, p.errorAt(sexp, "malformed match")
+	}
+	return &Match{exp, clauses, p.spanOf(sexp)}, nil
+}
+
+func (p *Parser) parseMatchClause(sexp Value) (*MatchClause, error) {
+	if !sexp.isCons() || !sexp.tailValue().isCons() {
+		return nil, p.errorAt(sexp, "expected clause (pattern expr ...) or (pattern when guard expr ...) in match")
+	}
+	names := make([]string, 0)
+	pattern, err := p.parsePattern(sexp.headValue(), &names)
+	if err != nil {
+		return nil, err
+	}
+	next := sexp.tailValue()
+	var guard AST
+	if parseKeyword(kw_WHEN, next.headValue()) {
+		if !next.tailValue().isCons() {
+			return nil, p.errorAt(sexp, "expected guard after when in match clause")
+		}
+		guard, err = p.parseElement(next.tailValue())
+		if err != nil {
+			return nil, err
+		}
+		next = next.tailValue().tailValue()
+	}
+	body, err := p.parseBody("match", sexp, next)
+	if err != nil {
+		return nil, err
+	}
+	return &MatchClause{pattern, names, guard, body}, nil
+}
+
+// _                     matches anything
+// name                  matches anything and binds it to name
+// 'datum                matches a value equal to datum
+// (p ...) (p ... . p)   matches a list
+// (array p ...)         matches an array, with an optional . p for the rest
+// (dict (key p) ...)    matches a dict having at least those keys
+// #[p ...]              same as (array p ...)
+// #dict((key p) ...)    same as (dict (key p) ...)
+// anything else is a literal
+
+func (p *Parser) parsePattern(sexp Value, names *[]string) (Pattern, error) {
+	if sexp.isSymbol() {
+		name := sexp.strValue()
+		if name == kw_WILDCARD {
+			return &PWildcard{}, nil
+		}
+		if isKeywordName(name) {
+			return &PLiteral{sexp}, nil
+		}
+		for _, n := range *names {
+			if n == name {
+				return nil, p.errorAt(sexp, "variable " + name + " appears twice in pattern")
+			}
+		}
+		*names = append(*names, name)
+		return &PVariable{name, len(*names) - 1}, nil
+	}
+	if isTagged(kw_QUOTE, sexp) {
+		return &PLiteral{sexp.tailValue().headValue()}, nil
+	}
+	if sexp.isArray() {
+		elems := make([]Pattern, len(sexp.getArray()))
+		for i, v := range sexp.getArray() {
+			elem, err := p.parsePattern(v, names)
+			if err != nil {
+				return nil, err
+			}
+			elems[i] = elem
+		}
+		return &PArray{elems, nil}, nil
+	}
+	if sexp.isDict() {
+		keys := sortedKeys(sexp.getDict())
+		pats := make([]Pattern, len(keys))
+		for i, k := range keys {
+			pat, err := p.parsePattern(sexp.getDict()[k], names)
+			if err != nil {
+				return nil, err
+			}
+			pats[i] = pat
+		}
+		return &PDict{keys, pats}, nil
+	}
+	if !sexp.isCons() {
+		return &PLiteral{sexp}, nil
+	}
+	if parseKeyword(kw_ARRAY, sexp.headValue()) {
+		return p.parseArrayPattern(sexp, names)
+	}
+	if parseKeyword(kw_DICT, sexp.headValue()) {
+		return p.parseDictPattern(sexp, names)
+	}
+	if constructor := p.constructorOf(sexp.headValue()); constructor != nil {
+		return p.parseRecordPattern(constructor.variant, sexp, names)
+	}
+	return p.parseListPattern(sexp, names)
+}
+
+// the constructor a symbol refers to, if any
+
+func (p *Parser) constructorOf(sexp Value) *VConstructor {
+	if p.env == nil || !sexp.isSymbol() {
+		return nil
+	}
+	v, err := p.env.find(sexp.strValue())
+	if err != nil {
+		return nil
+	}
+	constructor, _ := v.(*VConstructor)
+	return constructor
+}
+
+func (p *Parser) parseRecordPattern(variant *Variant, sexp Value, names *[]string) (Pattern, error) {
+	pats := make([]Pattern, 0)
+	current := sexp.tailValue()
+	for current.isCons() {
+		pat, err := p.parsePattern(current.headValue(), names)
+		if err != nil {
+			return nil, err
+		}
+		pats = append(pats, pat)
+		current = current.tailValue()
+	}
+	if !current.isEmpty() || len(pats) != len(variant.fields) {
+		return nil, p.errorAt(sexp, fmt.Sprintf("constructor pattern %s needs %d patterns", variant.name, len(variant.fields)))
+	}
+	return &PRecord{variant, pats}, nil
+}
+
+func (p *Parser) parseListPattern(sexp Value, names *[]string) (Pattern, error) {
+	elems := make([]Pattern, 0)
+	current := sexp
+	for current.isCons() {
+		elem, err := p.parsePattern(current.headValue(), names)
+		if err != nil {
+			return nil, err
+		}
+		elems = append(elems, elem)
+		current = current.tailValue()
+	}
+	var result Pattern
+	if current.isEmpty() {
+		result = &PLiteral{current}
+	} else {
+		tail, err := p.parsePattern(current, names)
+		if err != nil {
+			return nil, err
+		}
+		result = tail
+	}
+	for i := len(elems) - 1; i >= 0; i-- {
+		result = &PCons{elems[i], result}
+	}
+	return result, nil
+}
+
+func (p *Parser) parseArrayPattern(sexp Value, names *[]string) (Pattern, error) {
+	elems := make([]Pattern, 0)
+	current := sexp.tailValue()
+	for current.isCons() {
+		elem, err := p.parsePattern(current.headValue(), names)
+		if err != nil {
+			return nil, err
+		}
+		elems = append(elems, elem)
+		current = current.tailValue()
+	}
+	if current.isEmpty() {
+		return &PArray{elems, nil}, nil
+	}
+	rest, err := p.parsePattern(current, names)
+	if err != nil {
+		return nil, err
+	}
+	return &PArray{elems, rest}, nil
+}
+
+func (p *Parser) parseDictPattern(sexp Value, names *[]string) (Pattern, error) {
+	keys := make([]*VSymbol, 0)
+	pats := make([]Pattern, 0)
+	current := sexp.tailValue()
+	for current.isCons() {
+		item := current.headValue()
+		if listLength(item) != 2 || !listLast(item).isEmpty() || !item.headValue().isSymbol() {
+			return nil, p.errorAt(item, "expected (key pattern) in dict pattern")
+		}
+		pat, err := p.parsePattern(item.tailValue().headValue(), names)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, item.headValue().(*VSymbol))
+		pats = append(pats, pat)
+		current = current.tailValue()
+	}
+	if !current.isEmpty() {
+		return nil, p.errorAt(sexp, "malformed dict pattern")
+	}
+	return &PDict{keys, pats}, nil
+}
+
+// (module-header name (import spec ...) (export name ...))
+// the definition is named module-header, so a module has at most one
+
+func (p *Parser) parseModuleHeader(sexp Value) (*Def, error) {
+	next := sexp.tailValue()
+	if !next.isCons() || !next.headValue().isSymbol() {
+		return nil, p.errorAt(sexp, "expected module name in module-header")
+	}
+	header := &ModuleHeader{next.headValue().strValue(), []*Import{}, nil}
+	current := next.tailValue()
+	for current.isCons() {
+		clause := current.headValue()
+		if !clause.isCons() || !listLast(clause).isEmpty() {
+			return nil, p.errorAt(clause, "expected import or export clause in module-header")
+		}
+		if parseKeyword(kw_IMPORT, clause.headValue()) {
+			for specs := clause.tailValue(); specs.isCons(); specs = specs.tailValue() {
+				imp, err := p.parseImport(specs.headValue())
+				if err != nil {
+					return nil, err
+				}
+				header.imports = append(header.imports, imp)
+			}
+		} else if parseKeyword(kw_EXPORT, clause.headValue()) {
+			if header.exports == nil {
+				header.exports = []string{}
+			}
+			for names := clause.tailValue(); names.isCons(); names = names.tailValue() {
+				name := names.headValue()
+				if !name.isSymbol() {
+					return nil, p.errorAt(name, "exported name not a symbol")
+				}
+				if isPrivateName(name.strValue()) {
+					return nil, p.errorAtHead(names, "cannot export private name " + name.strValue())
+				}
+				header.exports = append(header.exports, name.strValue())
+			}
+		} else {
+			return nil, p.errorAt(clause, "expected import or export clause in module-header")
+		}
+		current = current.tailValue()
+	}
+	if !current.isEmpty() {
+		return nil, p.errorAt(sexp, "malformed module-header")
+	}
+	return &Def{name: kw_MODULE_HEADER, typ: DEF_HEADER, span: p.spanOf(sexp), header: header}, nil
+}
+
+// module  or  (module name ...)  where a name can be (name local)
+
+func (p *Parser) parseImport(spec Value) (*Import, error) {
+	if spec.isSymbol() {
+		return &Import{spec.strValue(), nil, nil}, nil
+	}
+	if !spec.isCons() || !spec.headValue().isSymbol() || !listLast(spec).isEmpty() {
+		return nil, p.errorAt(spec, "expected module or (module name ...) in import")
+	}
+	imp := &Import{spec.headValue().strValue(), []string{}, []string{}}
+	for names := spec.tailValue(); names.isCons(); names = names.tailValue() {
+		name := names.headValue()
+		if name.isSymbol() {
+			imp.names = append(imp.names, name.strValue())
+			imp.locals = append(imp.locals, name.strValue())
+			continue
+		}
+		if listLength(name) != 2 || !listLast(name).isEmpty() || !name.headValue().isSymbol() || !name.tailValue().headValue().isSymbol() {
+			return nil, p.errorAt(name, "expected name or (name local) in import")
+		}
+		imp.names = append(imp.names, name.headValue().strValue())
+		imp.locals = append(imp.locals, name.tailValue().headValue().strValue())
+	}
+	return imp, nil
 }
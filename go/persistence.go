@@ -0,0 +1,348 @@
+package main
+
+import "fmt"
+import "os"
+import "os/exec"
+import "path/filepath"
+import "strings"
+import "crypto/rand"
+import "encoding/hex"
+
+// Persistence layer
+//
+// Same layout as the Python prototype: a sqlite database source.db
+// with a table source(module, name, uuid), and one file s<uuid>.rg per
+// definition holding its source text.
+//
+// There is no sqlite driver in the standard library, so we go through
+// the sqlite3 command-line tool.
+
+const sourcePath = "source"
+const sourceDB = "source.db"
+
+const sqlSep = "\x1f"
+
+type Storage struct {
+	path string
+}
+
+func storageExists(path string) bool {
+	_, err := os.Stat(filepath.Join(path, sourceDB))
+	return err == nil
+}
+
+func mkStorage(path string) (*Storage, error) {
+	if _, err := exec.LookPath("sqlite3"); err != nil {
+		return nil, fmt.Errorf("cannot find sqlite3 executable")
+	}
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return nil, err
+	}
+	storage := &Storage{path}
+	_, err := storage.query(`CREATE TABLE IF NOT EXISTS source (
+                                   module TEXT,
+                                   name TEXT,
+                                   uuid TEXT,
+                                   PRIMARY KEY (module, name)
+                                 )`)
+	if err != nil {
+		return nil, err
+	}
+	return storage, nil
+}
+
+func sqlQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+func (s *Storage) query(sql string) ([][]string, error) {
+	cmd := exec.Command("sqlite3", "-batch", "-noheader", "-separator", sqlSep, filepath.Join(s.path, sourceDB), sql)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("database error - %s", strings.TrimSpace(string(out)))
+	}
+	rows := [][]string{}
+	for _, line := range strings.Split(string(out), "\n") {
+		if line == "" {
+			continue
+		}
+		rows = append(rows, strings.Split(line, sqlSep))
+	}
+	return rows, nil
+}
+
+func (s *Storage) getModules() ([]string, error) {
+	rows, err := s.query(`SELECT DISTINCT module FROM source ORDER BY module`)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]string, len(rows))
+	for i, row := range rows {
+		result[i] = row[0]
+	}
+	return result, nil
+}
+
+// return names and uuids of a module's definitions in order of creation
+func (s *Storage) getEntries(module string) ([]string, []string, error) {
+	rows, err := s.query(fmt.Sprintf(`SELECT name, uuid FROM source WHERE module = %s ORDER BY rowid`, sqlQuote(module)))
+	if err != nil {
+		return nil, nil, err
+	}
+	names := make([]string, len(rows))
+	uids := make([]string, len(rows))
+	for i, row := range rows {
+		if len(row) != 2 {
+			return nil, nil, fmt.Errorf("malformed entry in module %s", module)
+		}
+		names[i] = row[0]
+		uids[i] = row[1]
+	}
+	return names, uids, nil
+}
+
+func (s *Storage) getUid(module string, name string) (string, error) {
+	rows, err := s.query(fmt.Sprintf(`SELECT uuid FROM source WHERE module = %s AND name = %s`, sqlQuote(module), sqlQuote(name)))
+	if err != nil {
+		return "", err
+	}
+	if len(rows) == 0 {
+		return "", nil
+	}
+	return rows[0][0], nil
+}
+
+func (s *Storage) sourceFile(uid string) string {
+	return filepath.Join(s.path, fmt.Sprintf("s%s.rg", uid))
+}
+
+func (s *Storage) readSource(uid string) (string, error) {
+	content, err := os.ReadFile(s.sourceFile(uid))
+	if err != nil {
+		return "", err
+	}
+	return string(content), nil
+}
+
+// create or replace the source of a definition
+func (s *Storage) saveEntry(module string, name string, source string) error {
+	uid, err := s.getUid(module, name)
+	if err != nil {
+		return err
+	}
+	if uid == "" {
+		uid, err = freshUid()
+		if err != nil {
+			return err
+		}
+		_, err = s.query(fmt.Sprintf(`INSERT INTO source (module, name, uuid) VALUES (%s, %s, %s)`, sqlQuote(module), sqlQuote(name), sqlQuote(uid)))
+		if err != nil {
+			return err
+		}
+	}
+	return os.WriteFile(s.sourceFile(uid), []byte(source + "\n"), 0644)
+}
+
+// remove a module's definitions along with their source files
+func (s *Storage) deleteModule(module string) error {
+	_, uids, err := s.getEntries(module)
+	if err != nil {
+		return err
+	}
+	for _, uid := range uids {
+		if err := os.Remove(s.sourceFile(uid)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	_, err = s.query(fmt.Sprintf(`DELETE FROM source WHERE module = %s`, sqlQuote(module)))
+	return err
+}
+
+func (s *Storage) renameModule(module string, newName string) error {
+	_, err := s.query(fmt.Sprintf(`UPDATE source SET module = %s WHERE module = %s`, sqlQuote(newName), sqlQuote(module)))
+	return err
+}
+
+func freshUid() (string, error) {
+	bytes := make([]byte, 16)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(bytes), nil
+}
+
+// modules are loaded after the modules they use, so that values,
+// macros and constructors from other modules are available
+
+func (eco *Ecosystem) loadModules(storage *Storage) error {
+	modules, err := storage.getModules()
+	if err != nil {
+		return err
+	}
+	for _, module := range modules {
+		if _, ok := eco.modulesEnv[module]; !ok {
+			eco.mkEnv(module, map[string]Value{})
+		}
+	}
+	order, err := eco.loadOrder(storage, modules)
+	if err != nil {
+		return err
+	}
+	for _, module := range order {
+		count, err := eco.loadModule(storage, module, eco.modulesEnv[module])
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, ";; Loading module %s - %d\n", module, count)
+	}
+	return nil
+}
+
+// the stored modules that module uses, through its header or
+// config::lookup-path, or through qualified identifiers
+
+func (eco *Ecosystem) moduleDependencies(storage *Storage, module string, modules []string) ([]string, error) {
+	names, uids, err := storage.getEntries(module)
+	if err != nil {
+		return nil, err
+	}
+	sources := []string{}
+	for i, uid := range uids {
+		src, err := storage.readSource(uid)
+		if err != nil {
+			return nil, err
+		}
+		if names[i] == kw_MODULE_HEADER {
+			// headers do not depend on other modules
+			if err := loadEntry(storage, uid, eco.modulesEnv[module]); err != nil {
+				return nil, err
+			}
+		}
+		sources = append(sources, src)
+	}
+	used := map[string]bool{}
+	if header, ok := eco.headers[module]; ok {
+		for _, imp := range header.imports {
+			used[imp.module] = true
+		}
+	} else {
+		for _, other := range eco.lookupPath() {
+			used[other] = true
+		}
+	}
+	result := []string{}
+	for _, other := range modules {
+		if other == module {
+			continue
+		}
+		if used[other] {
+			result = append(result, other)
+			continue
+		}
+		for _, src := range sources {
+			if refersTo(src, other) {
+				result = append(result, other)
+				break
+			}
+		}
+	}
+	return result, nil
+}
+
+// modules ordered so that each comes after the modules it uses
+// modules that use each other are reported and loaded in name order
+
+func (eco *Ecosystem) loadOrder(storage *Storage, modules []string) ([]string, error) {
+	deps := map[string][]string{}
+	for _, module := range modules {
+		used, err := eco.moduleDependencies(storage, module, modules)
+		if err != nil {
+			return nil, err
+		}
+		deps[module] = used
+	}
+	order := []string{}
+	done := map[string]bool{}
+	active := map[string]bool{}
+	var visit func(module string, path []string)
+	visit = func(module string, path []string) {
+		if done[module] {
+			return
+		}
+		path = append(path, module)
+		if active[module] {
+			reportError("LOAD", fmt.Errorf("module cycle %s", strings.Join(path, " -> ")))
+			return
+		}
+		active[module] = true
+		for _, other := range deps[module] {
+			visit(other, path)
+		}
+		active[module] = false
+		done[module] = true
+		order = append(order, module)
+	}
+	for _, module := range modules {
+		visit(module, []string{})
+	}
+	return order, nil
+}
+
+// load the stored definitions of a module into env
+// returns the number of definitions loaded
+
+func (eco *Ecosystem) loadModule(storage *Storage, module string, env *Env) (int, error) {
+	names, uids, err := storage.getEntries(module)
+	if err != nil {
+		return 0, err
+	}
+	// the header comes first, since other entries may need its imports
+	for i, name := range names {
+		if name == kw_MODULE_HEADER && i > 0 {
+			names = append(append([]string{name}, names[:i]...), names[i + 1:]...)
+			uids = append(append([]string{uids[i]}, uids[:i]...), uids[i + 1:]...)
+			break
+		}
+	}
+	count := 0
+	for i, uid := range uids {
+		if err := loadEntry(storage, uid, env); err != nil {
+			reportError("LOAD", fmt.Errorf("%s%s%s - %w", module, moduleSep, names[i], err))
+			continue
+		}
+		count += 1
+	}
+	return count, nil
+}
+
+func loadEntry(storage *Storage, uid string, env *Env) error {
+	d, err := parseEntry(storage, uid, env)
+	if err != nil {
+		return err
+	}
+	return evalDef(d, env)
+}
+
+func parseEntry(storage *Storage, uid string, env *Env) (*Def, error) {
+	src, err := storage.readSource(uid)
+	if err != nil {
+		return nil, err
+	}
+	return parseSource(storage.sourceFile(uid), src, env)
+}
+
+func parseSource(name string, src string, env *Env) (*Def, error) {
+	reader := newReader(name, src)
+	v, _, err := reader.read(src)
+	if err != nil {
+		return nil, err
+	}
+	d, _, err := newParser(reader, env).parseTop(v)
+	if err != nil {
+		return nil, err
+	}
+	if d == nil {
+		return nil, fmt.Errorf("source is not a definition")
+	}
+	return d, nil
+}
@@ -2,6 +2,7 @@
 
 import "fmt"
 import "strings"
+import "sort"
 
 type PrimitiveDesc struct {
 	name string
@@ -41,6 +42,15 @@ func listAppend (v1 Value, v2 Value) Value {
 	return result
 }
 
+// the tail past the last cons cell of a list, () for a proper list
+func listLast (v Value) Value {
+	current := v
+	for current.isCons() {
+		current = current.tailValue()
+	}
+	return current
+}
+
 func allConses(vs []Value) bool {
 	for _, v := range vs {
 		if !v.isCons() {
@@ -50,10 +60,20 @@ func allConses(vs []Value) bool {
 	return true
 }
 
+// primitives by name, for the reader's #primitive("name")
+// filled in when the primitives are created
+
+var primitiveTable = map[string]*VPrimitive{}
+
 func corePrimitives() map[string]Value {
 	bindings := map[string]Value{}
 	for _, d := range CORE_PRIMITIVES {
-		bindings[d.name] = &VPrimitive{d.name, mkPrimitive(d)}
+		prim := &VPrimitive{d.name, mkPrimitive(d)}
+		primitiveTable[d.name] = prim
+		bindings[d.name] = prim
+	}
+	for name, g := range coreGenerics() {
+		bindings[name] = g
 	}
 	return bindings
 }
@@ -61,7 +81,9 @@ func corePrimitives() map[string]Value {
 func shellPrimitives() map[string]Value {
 	bindings := map[string]Value{}
 	for _, d := range SHELL_PRIMITIVES {
-		bindings[d.name] = &VPrimitive{d.name, mkPrimitive(d)}
+		prim := &VPrimitive{d.name, mkPrimitive(d)}
+		primitiveTable[d.name] = prim
+		bindings[d.name] = prim
 	}
 	return bindings
 }
@@ -82,33 +104,40 @@ func mkPrimitive(d PrimitiveDesc) func([]Value)(Value, error) {
 	
 func checkArgType(name string, arg Value, pred func(Value)bool) error {
 	if !pred(arg) {
-		return fmt.Errorf("%s - wrong argument type %s", name, arg.typ())
+		return newError(ERR_TYPE, arg, "%s - wrong argument type %s", name, arg.typ())
 	}
 	return nil
 }
 
 func checkMinArgs(name string, args []Value, n int) error {
 	if len(args) < n {
-		return fmt.Errorf("%s - too few arguments %d", name, len(args))
+		return newError(ERR_ARITY, nil, "%s - too few arguments %d", name, len(args))
 	}
 	return nil
 }
 
 func checkMaxArgs(name string, args []Value, n int) error {
 	if len(args) > n {
-		return fmt.Errorf("%s - too many arguments %d", name, len(args))
+		return newError(ERR_ARITY, nil, "%s - too many arguments %d", name, len(args))
 	}
 	return nil
 }
 
 func checkExactArgs(name string, args []Value, n int) error {
 	if len(args) != n {
-		return fmt.Errorf("%s - wrong number of arguments %d", name, len(args))
+		return newError(ERR_ARITY, nil, "%s - wrong number of arguments %d", name, len(args))
 	}
 	return nil
 }
 
+// ints that fit in an int, as used for indices and lengths
+
 func isInt(v Value) bool {
+	_, ok := v.(*VInteger)
+	return ok
+}
+
+func isNumber(v Value) bool {
 	return v.isNumber()
 }
 
@@ -128,22 +157,58 @@ func isList(v Value) bool {
 	return v.isCons() || v.isEmpty()
 }
 
+func isArray(v Value) bool {
+	return v.isArray()
+}
+
+func isDict(v Value) bool {
+	return v.isDict()
+}
+
 func isReference(v Value) bool {
 	return v.isRef()
 }
 
-func mkNumPredicate(pred func(int, int)bool) func(string, []Value)(Value, error) {
+func isError(v Value) bool {
+	_, ok := v.(*VError)
+	return ok
+}
+
+// pred gets the result of comparing the two arguments with numCompare
+
+func mkNumPredicate(pred func(int)bool) func(string, []Value)(Value, error) {
 	return func(name string, args []Value) (Value, error) {
 		if err := checkExactArgs(name, args, 2); err != nil {
 			return nil, err
 		}
-		if err := checkArgType(name, args[0], isInt); err != nil {
+		if err := checkArgType(name, args[0], isNumber); err != nil {
 			return nil, err
 		}
-		if err := checkArgType(name, args[1], isInt); err != nil {
+		if err := checkArgType(name, args[1], isNumber); err != nil {
 			return nil, err
 		}
-		return &VBoolean{pred(args[0].intValue(), args[1].intValue())}, nil
+		return &VBoolean{pred(numCompare(args[0], args[1]))}, nil
+	}
+}
+
+func mkNumFunction(pred func(Value)bool, f func(string, Value)(Value, error)) func(string, []Value)(Value, error) {
+	return func(name string, args []Value) (Value, error) {
+		if err := checkArgType(name, args[0], pred); err != nil {
+			return nil, err
+		}
+		return f(name, args[0])
+	}
+}
+
+func mkNumFunction2(pred func(Value)bool, f func(string, Value, Value)(Value, error)) func(string, []Value)(Value, error) {
+	return func(name string, args []Value) (Value, error) {
+		if err := checkArgType(name, args[0], pred); err != nil {
+			return nil, err
+		}
+		if err := checkArgType(name, args[1], pred); err != nil {
+			return nil, err
+		}
+		return f(name, args[0], args[1])
 	}
 }
 
@@ -152,53 +217,128 @@ var CORE_PRIMITIVES = []PrimitiveDesc{
 	PrimitiveDesc{
 		"type", 1, 1,
 		func(name string, args []Value) (Value, error) {
-			return &VSymbol{args[0].typ()}, nil
+			return intern(args[0].typ()), nil
 		},
 	},
 	
 	PrimitiveDesc{
 		"+", 0, -1,
 		func(name string, args []Value) (Value, error) {
-			v := 0
+			var v Value = &VInteger{0}
 			for _, arg := range args {
-				if err := checkArgType(name, arg, isInt); err != nil { 
+				if err := checkArgType(name, arg, isNumber); err != nil { 
 					return nil, err
 				}
-				v += arg.intValue()
+				v = numAdd(v, arg)
 			}
-			return &VInteger{v}, nil
+			return v, nil
 		},
 	},
 
 	PrimitiveDesc{
 		"*", 0, -1,
 		func(name string, args []Value) (Value, error) {
-			v := 1
+			var v Value = &VInteger{1}
 			for _, arg := range args {
-				if err := checkArgType(name, arg, isInt); err != nil { 
+				if err := checkArgType(name, arg, isNumber); err != nil { 
 					return nil, err
 				}
-				v *= arg.intValue()
+				v = numMul(v, arg)
 			}
-			return &VInteger{v}, nil
+			return v, nil
 		},
 	},
 	
 	PrimitiveDesc{
 		"-", 1, -1,
 		func(name string, args []Value) (Value, error) {
-			v := args[0].intValue()
+			if err := checkArgType(name, args[0], isNumber); err != nil { 
+				return nil, err
+			}
+			v := args[0]
 			if len(args) > 1 { 
 				for _, arg := range args[1:] {
-					if err := checkArgType(name, arg, isInt); err != nil { 
+					if err := checkArgType(name, arg, isNumber); err != nil { 
 						return nil, err
 					}
-					v -= arg.intValue()
+					v = numSub(v, arg)
 				}
 			} else {
-				v = -v
+				v = numSub(&VInteger{0}, v)
 			}
-			return &VInteger{v}, nil
+			return v, nil
+		},
+	},
+
+	PrimitiveDesc{
+		"/", 1, -1,
+		func(name string, args []Value) (Value, error) {
+			if err := checkArgType(name, args[0], isNumber); err != nil { 
+				return nil, err
+			}
+			if len(args) == 1 {
+				return numDiv(name, &VInteger{1}, args[0])
+			}
+			v := args[0]
+			for _, arg := range args[1:] {
+				if err := checkArgType(name, arg, isNumber); err != nil { 
+					return nil, err
+				}
+				var err error
+				v, err = numDiv(name, v, arg)
+				if err != nil {
+					return nil, err
+				}
+			}
+			return v, nil
+		},
+	},
+
+	PrimitiveDesc{"quotient", 2, 2,
+		mkNumFunction2(isExactInteger, numQuotient),
+	},
+
+	PrimitiveDesc{"remainder", 2, 2,
+		mkNumFunction2(isExactInteger, numRemainder),
+	},
+
+	PrimitiveDesc{"modulo", 2, 2,
+		mkNumFunction2(isExactInteger, numModulo),
+	},
+
+	PrimitiveDesc{"expt", 2, 2,
+		mkNumFunction2(isNumber, numExpt),
+	},
+
+	PrimitiveDesc{"sqrt", 1, 1,
+		mkNumFunction(isNumber, numSqrt),
+	},
+
+	PrimitiveDesc{"floor", 1, 1,
+		mkNumFunction(isNumber, func(name string, v Value) (Value, error) { return numFloor(v), nil }),
+	},
+
+	PrimitiveDesc{"round", 1, 1,
+		mkNumFunction(isNumber, func(name string, v Value) (Value, error) { return numRound(v), nil }),
+	},
+
+	PrimitiveDesc{"exact->inexact", 1, 1,
+		mkNumFunction(isNumber, func(name string, v Value) (Value, error) { return numExactToInexact(v), nil }),
+	},
+
+	PrimitiveDesc{"inexact->exact", 1, 1,
+		mkNumFunction(isNumber, numInexactToExact),
+	},
+
+	PrimitiveDesc{"exact?", 1, 1,
+		func(name string, args []Value) (Value, error) {
+			return &VBoolean{isExact(args[0])}, nil
+		},
+	},
+
+	PrimitiveDesc{"integer?", 1, 1,
+		func(name string, args []Value) (Value, error) {
+			return &VBoolean{isExactInteger(args[0])}, nil
 		},
 	},
 
@@ -215,19 +355,19 @@ var CORE_PRIMITIVES = []PrimitiveDesc{
 	},
 
 	PrimitiveDesc{"<", 2, 2,
-		mkNumPredicate(func(n1 int, n2 int) bool { return n1 < n2 }),
+		mkNumPredicate(func(c int) bool { return c < 0 }),
 	},
 
 	PrimitiveDesc{"<=", 2, 2,
-		mkNumPredicate(func(n1 int, n2 int) bool { return n1 <= n2 }),
+		mkNumPredicate(func(c int) bool { return c <= 0 }),
 	},
 
 	PrimitiveDesc{">", 2, 2,
-		mkNumPredicate(func(n1 int, n2 int) bool { return n1 > n2 }),
+		mkNumPredicate(func(c int) bool { return c > 0 }),
 	},
 
 	PrimitiveDesc{">=", 2, 2,
-		mkNumPredicate(func(n1 int, n2 int) bool { return n1 >= n2 }),
+		mkNumPredicate(func(c int) bool { return c >= 0 }),
 	},
 
 	PrimitiveDesc{"not", 1, 1,
@@ -319,7 +459,7 @@ var CORE_PRIMITIVES = []PrimitiveDesc{
 				current = current.tailValue()
 			}
 			if !current.isEmpty() {
-				return nil, fmt.Errorf("%s - malformed list", name)
+				return nil, newError(ERR_TYPE, nil, "%s - malformed list", name)
 			}
 			return args[0].apply(arguments)
 		},
@@ -334,51 +474,13 @@ var CORE_PRIMITIVES = []PrimitiveDesc{
 		},
 	},
 
-	PrimitiveDesc{
-		"append", 0, -1,
-		func(name string, args []Value) (Value, error) {
-			if len(args) == 0 {
-				return &VEmpty{}, nil
-			}
-			if err := checkArgType(name, args[len(args) - 1], isList); err != nil {
-				return nil, err
-			}
-			result := args[len(args) - 1]
-			for i := len(args) - 2; i >= 0; i -= 1 { 
-				if err := checkArgType(name, args[i], isList); err != nil { 
-					return nil, err
-				}
-				result = listAppend(args[i], result)
-			}
-			return result, nil
-		},
-	},
-
-	PrimitiveDesc{"reverse", 1, 1,
-		func(name string, args []Value) (Value, error) {
-			if err := checkArgType(name, args[0], isList); err != nil {
-				return nil, err
-			}
-			var result Value = &VEmpty{}
-			current := args[0]
-			for current.isCons() {
-				result = &VCons{head: current.headValue(), tail: result}
-				current = current.tailValue()
-			}
-			if !current.isEmpty() {
-				return nil, fmt.Errorf("%s - malformed list", name)
-			}
-			return result, nil
-		},
-	},
-
 	PrimitiveDesc{"head", 1, 1,
 		func(name string, args []Value) (Value, error) {
 			if err := checkArgType(name, args[0], isList); err != nil {
 				return nil, err
 			}
 			if args[0].isEmpty() {
-				return nil, fmt.Errorf("%s - empty list argument", name)
+				return nil, newError(ERR_INDEX, args[0], "%s - empty list argument", name)
 			}
 			return args[0].headValue(), nil
 		},
@@ -390,7 +492,7 @@ var CORE_PRIMITIVES = []PrimitiveDesc{
 				return nil, err
 			}
 			if args[0].isEmpty() {
-				return nil, fmt.Errorf("%s - empty list argument", name)
+				return nil, newError(ERR_INDEX, args[0], "%s - empty list argument", name)
 			}
 			return args[0].tailValue(), nil
 		},
@@ -406,24 +508,6 @@ var CORE_PRIMITIVES = []PrimitiveDesc{
 		},
 	},
 
-	PrimitiveDesc{"length", 1, 1,
-		func(name string, args []Value) (Value, error) {
-			if err := checkArgType(name, args[0], isList); err != nil {
-				return nil, err
-			}
-			count := 0
-			current := args[0]
-			for current.isCons() {
-				count += 1
-				current = current.tailValue()
-			}
-			if !current.isEmpty() { 
-				return nil, fmt.Errorf("%s - malformed list", name)
-			}
-			return &VInteger{count}, nil
-		},
-	},
-
 	PrimitiveDesc{"nth", 2, 2,
 		func(name string, args []Value) (Value, error) {
 			if err := checkArgType(name, args[0], isList); err != nil {
@@ -444,51 +528,7 @@ var CORE_PRIMITIVES = []PrimitiveDesc{
 					}
 				}
 			}
-			return nil, fmt.Errorf("%s - index %d out of bound", name, args[1].intValue())
-		},
-	},
-
-	PrimitiveDesc{"map", 2, -1,
-		func(name string, args []Value) (Value, error) {
-			if err := checkArgType(name, args[0], isFunction); err != nil {
-				return nil, err
-			}
-			for i := range args[1:] {
-				if err := checkArgType(name, args[i + 1], isList); err != nil {
-					return nil, err
-				}
-			}
-			var result Value = nil
-			var current_result *VCons = nil
-			currents := make([]Value, len(args) - 1)
-			firsts := make([]Value, len(args) - 1)
-			for i := range args[1:] {
-				currents[i] = args[i + 1]
-			}
-			for allConses(currents) {
-				for i := range currents {
-					firsts[i] = currents[i].headValue()
-				}
-				v, err := args[0].apply(firsts)
-				if err != nil {
-					return nil, err
-				}
-				cell := &VCons{head: v, tail: nil}
-				if current_result == nil {
-					result = cell
-				} else {
-					current_result.tail = cell
-				}
-				current_result = cell
-				for i := range currents {
-					currents[i] = currents[i].tailValue()
-				}
-			}
-			if current_result == nil {
-				return &VEmpty{}, nil
-			}
-			current_result.tail = &VEmpty{}
-			return result, nil
+			return nil, newError(ERR_INDEX, args[1], "%s - index %d out of bound", name, args[1].intValue())
 		},
 	},
 
@@ -552,7 +592,7 @@ var CORE_PRIMITIVES = []PrimitiveDesc{
 				current = current.tailValue()
 			}
 			if !current.isEmpty() {
-				return nil, fmt.Errorf("%s - malformed list", name)
+				return nil, newError(ERR_TYPE, nil, "%s - malformed list", name)
 			}				
 			if current_result == nil {
 				return &VEmpty{}, nil
@@ -578,7 +618,7 @@ var CORE_PRIMITIVES = []PrimitiveDesc{
 				current = current.tailValue()
 			}
 			if !current.isEmpty() {
-				return nil, fmt.Errorf("%s - malformed list", name)
+				return nil, newError(ERR_TYPE, nil, "%s - malformed list", name)
 			}
 			// then fold it
 			result := args[2]
@@ -592,7 +632,7 @@ var CORE_PRIMITIVES = []PrimitiveDesc{
 				current = current.tailValue()
 			}
 			if !current.isEmpty() {
-				return nil, fmt.Errorf("%s - malformed list", name)
+				return nil, newError(ERR_TYPE, nil, "%s - malformed list", name)
 			}
 			return result, nil
 		},
@@ -617,12 +657,25 @@ var CORE_PRIMITIVES = []PrimitiveDesc{
 				current = current.tailValue()
 			}
 			if !current.isEmpty() {
-				return nil, fmt.Errorf("%s - malformed list", name)
+				return nil, newError(ERR_TYPE, nil, "%s - malformed list", name)
 			}
 			return result, nil
 		},
 	},
 
+	PrimitiveDesc{"gensym", 0, 1,
+		func(name string, args []Value) (Value, error) {
+			prefix := "g"
+			if len(args) > 0 {
+				if err := checkArgType(name, args[0], isString); err != nil {
+					return nil, err
+				}
+				prefix = args[0].strValue()
+			}
+			return intern(fresh("__" + prefix)), nil
+		},
+	},
+
 	PrimitiveDesc{"ref", 1, 1,
 		func(name string, args []Value) (Value, error) {
 			return &VReference{args[0]}, nil
@@ -645,6 +698,22 @@ var CORE_PRIMITIVES = []PrimitiveDesc{
 	// 	},
 	// },
 	
+	// the reference cell holding a field of an object
+	
+	PrimitiveDesc{"lookup-field", 2, 2,
+		func(name string, args []Value) (Value, error) {
+			return lookupField(name, args[0], args[1])
+		},
+	},
+	
+	// a method of an object, with this bound to the object
+	
+	PrimitiveDesc{"lookup-method", 2, 2,
+		func(name string, args []Value) (Value, error) {
+			return lookupMethod(name, args[0], args[1])
+		},
+	},
+	
 	PrimitiveDesc{"empty?", 1, 1,
 		func(name string, args []Value) (Value, error) {
 			return &VBoolean{args[0].isEmpty()}, nil
@@ -687,6 +756,13 @@ var CORE_PRIMITIVES = []PrimitiveDesc{
 		},
 	},
 	
+	PrimitiveDesc{"keyword?", 1, 1,
+		func(name string, args []Value) (Value, error) {
+			sym, ok := args[0].(*VSymbol)
+			return &VBoolean{ok && sym.isKeyword()}, nil
+		},
+	},
+
 	PrimitiveDesc{"symbol?", 1, 1,
 		func(name string, args []Value) (Value, error) {
 			return &VBoolean{args[0].isSymbol()}, nil
@@ -723,25 +799,91 @@ var CORE_PRIMITIVES = []PrimitiveDesc{
 	
 	PrimitiveDesc{"dict", 0, -1,
 		func(name string, args []Value) (Value, error) {
-			content := make(map[string]Value, len(args))
+			content := make(map[*VSymbol]Value, len(args))
 			for _, v := range args {
 				if !v.isCons() || !v.tailValue().isCons() || !v.tailValue().tailValue().isEmpty() {
-					return nil, fmt.Errorf("dict item not a pair - %s", v.display())
+					return nil, newError(ERR_TYPE, v, "dict item not a pair - %s", v.display())
 				}
 				if !v.headValue().isSymbol() {
-					return nil, fmt.Errorf("dict key is not a symbol - %s", v.headValue().display())
+					return nil, newError(ERR_TYPE, v.headValue(), "dict key is not a symbol - %s", v.headValue().display())
 				}
-				content[v.headValue().strValue()] = v.tailValue().headValue()
+				content[v.headValue().(*VSymbol)] = v.tailValue().headValue()
 			}
 			return &VDict{content}, nil
 		},
 	},
 
+	PrimitiveDesc{"print", 0, -1,
+		func(name string, args []Value) (Value, error) {
+			items := make([]string, len(args))
+			for i, arg := range args {
+				if arg.isString() {
+					items[i] = arg.strValue()
+				} else {
+					items[i] = arg.display()
+				}
+			}
+			fmt.Println(strings.Join(items, " "))
+			return &VNil{}, nil
+		},
+	},
+
 	PrimitiveDesc{"dict?", 1, 1,
 		func(name string, args []Value) (Value, error) {
 			return &VBoolean{args[0].isDict()}, nil
 		},
 	},
+
+	PrimitiveDesc{"error?", 1, 1,
+		func(name string, args []Value) (Value, error) {
+			return &VBoolean{isError(args[0])}, nil
+		},
+	},
+
+	PrimitiveDesc{"error-kind", 1, 1,
+		func(name string, args []Value) (Value, error) {
+			if err := checkArgType(name, args[0], isError); err != nil {
+				return nil, err
+			}
+			return intern(args[0].(*VError).err.kind), nil
+		},
+	},
+
+	PrimitiveDesc{"error-message", 1, 1,
+		func(name string, args []Value) (Value, error) {
+			if err := checkArgType(name, args[0], isError); err != nil {
+				return nil, err
+			}
+			return &VString{args[0].(*VError).err.msg}, nil
+		},
+	},
+
+	PrimitiveDesc{"error-value", 1, 1,
+		func(name string, args []Value) (Value, error) {
+			if err := checkArgType(name, args[0], isError); err != nil {
+				return nil, err
+			}
+			value := args[0].(*VError).err.value
+			if value == nil {
+				return &VNil{}, nil
+			}
+			return value, nil
+		},
+	},
+
+	PrimitiveDesc{"error-trace", 1, 1,
+		func(name string, args []Value) (Value, error) {
+			if err := checkArgType(name, args[0], isError); err != nil {
+				return nil, err
+			}
+			trace := args[0].(*VError).err.trace
+			var result Value = &VEmpty{}
+			for i := len(trace) - 1; i >= 0; i -= 1 {
+				result = &VCons{head: &VString{trace[i].str()}, tail: result}
+			}
+			return result, nil
+		},
+	},
 	
 }
 
@@ -766,13 +908,164 @@ var SHELL_PRIMITIVES = []PrimitiveDesc{
 			return &VNil{}, nil
 		},
 	},
+
+	PrimitiveDesc{
+		"load", 1, 1,
+		func(name string, args []Value) (Value, error) {
+			if err := checkArgType(name, args[0], isString); err != nil { 
+				return nil, err
+			}
+			failed, err := loadFile(context.ecosystem, args[0].strValue())
+			if err != nil {
+				return nil, err
+			}
+			if failed > 0 {
+				return nil, fmt.Errorf("%s - %d forms failed in %s", name, failed, args[0].strValue())
+			}
+			return &VNil{}, nil
+		},
+	},
 	
+	PrimitiveDesc{
+		"last-error", 0, 0,
+		func(name string, args []Value) (Value, error) {
+			if context.lastError == nil {
+				return &VNil{}, nil
+			}
+			return &VError{context.lastError}, nil
+		},
+	},
+
 	PrimitiveDesc{
 		"modules", 0, 0,
 		func(name string, args []Value) (Value, error) {
 			var result Value = &VEmpty{}
 			for m := range context.ecosystem.modulesEnv {
-				result = &VCons{head: &VSymbol{m}, tail: result}
+				result = &VCons{head: intern(m), tail: result}
+			}
+			return result, nil
+		},
+	},
+
+	PrimitiveDesc{
+		"new-module", 1, 1,
+		func(name string, args []Value) (Value, error) {
+			if err := checkArgType(name, args[0], isSymbol); err != nil {
+				return nil, err
+			}
+			if err := context.ecosystem.newModule(args[0].strValue()); err != nil {
+				return nil, fmt.Errorf("%s - %w", name, err)
+			}
+			return &VNil{}, nil
+		},
+	},
+
+	PrimitiveDesc{
+		"delete-module", 1, 1,
+		func(name string, args []Value) (Value, error) {
+			if err := checkArgType(name, args[0], isSymbol); err != nil {
+				return nil, err
+			}
+			module := args[0].strValue()
+			if err := context.ecosystem.deleteModule(module); err != nil {
+				return nil, fmt.Errorf("%s - %w", name, err)
+			}
+			if context.currentModule == module {
+				context.nextCurrentModule = scratchModule
+			}
+			return &VNil{}, nil
+		},
+	},
+
+	PrimitiveDesc{
+		"rename-module", 2, 2,
+		func(name string, args []Value) (Value, error) {
+			if err := checkArgType(name, args[0], isSymbol); err != nil {
+				return nil, err
+			}
+			if err := checkArgType(name, args[1], isSymbol); err != nil {
+				return nil, err
+			}
+			module, newName := args[0].strValue(), args[1].strValue()
+			if err := context.ecosystem.renameModule(module, newName); err != nil {
+				return nil, fmt.Errorf("%s - %w", name, err)
+			}
+			// the shell keeps the same environment under the new name
+			if context.currentModule == module {
+				context.currentModule = newName
+				context.nextCurrentModule = newName
+			}
+			return &VNil{}, nil
+		},
+	},
+
+	PrimitiveDesc{
+		"dependencies", 1, 1,
+		func(name string, args []Value) (Value, error) {
+			ref, err := shellRef(name, args[0])
+			if err != nil {
+				return nil, err
+			}
+			index := context.ecosystem.index
+			return refsToList(index.dependencies(ref)), nil
+		},
+	},
+
+	PrimitiveDesc{
+		"references", 1, 1,
+		func(name string, args []Value) (Value, error) {
+			ref, err := shellRef(name, args[0])
+			if err != nil {
+				return nil, err
+			}
+			index := context.ecosystem.index
+			return refsToList(index.references(ref)), nil
+		},
+	},
+
+	PrimitiveDesc{
+		"all-dependencies", 1, 1,
+		func(name string, args []Value) (Value, error) {
+			ref, err := shellRef(name, args[0])
+			if err != nil {
+				return nil, err
+			}
+			index := context.ecosystem.index
+			return refsToList(index.closure(ref, index.dependencies)), nil
+		},
+	},
+
+	PrimitiveDesc{
+		"all-references", 1, 1,
+		func(name string, args []Value) (Value, error) {
+			ref, err := shellRef(name, args[0])
+			if err != nil {
+				return nil, err
+			}
+			index := context.ecosystem.index
+			return refsToList(index.closure(ref, index.references)), nil
+		},
+	},
+
+	PrimitiveDesc{
+		"module-bindings", 1, 1,
+		func(name string, args []Value) (Value, error) {
+			if err := checkArgType(name, args[0], isSymbol); err != nil {
+				return nil, err
+			}
+			env, ok := context.ecosystem.modulesEnv[args[0].strValue()]
+			if !ok {
+				return nil, newError(ERR_UNBOUND, args[0], "%s - no such module %s", name, args[0].strValue())
+			}
+			names := []string{}
+			for n := range env.bindings {
+				names = append(names, n)
+			}
+			sort.Strings(names)
+			var result Value = &VEmpty{}
+			for i := len(names) - 1; i >= 0; i-- {
+				entry := &VCons{head: intern(names[i]), tail: &VCons{head: intern(bindingKind(env, names[i])), tail: &VEmpty{}}}
+				result = &VCons{head: entry, tail: result}
 			}
 			return result, nil
 		},
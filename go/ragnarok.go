@@ -1,10 +1,23 @@
 package main
 
 import "fmt"
+import "os"
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "--test" {
+		// ragnarok --test
+		if test() > 0 {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	if len(os.Args) > 1 {
+		// ragnarok file.rg [args]
+		eco := initialize(false)
+		script(eco, os.Args[1], os.Args[2:])
+	}
 	fmt.Println("Ragnarok/go 0.1.0")
-	eco := initialize()
+	eco := initialize(true)
 	shell(eco)
 }
 
@@ -1,13 +1,18 @@
 package main
 
+import "fmt"
 import "strconv"
+import "math"
+import "math/big"
 import "strings"
 import "regexp"
 import "errors"
+import "unicode"
+import "unicode/utf8"
 
 func readToken(token string, s string) (string, string) {
 	r, _ := regexp.Compile(`^` + token)
-	ss := strings.TrimSpace(s)
+	ss := strings.TrimLeftFunc(s, unicode.IsSpace)
 	match := r.FindStringIndex(ss)
 	if len(match) == 0 {
 		// no match
@@ -19,7 +24,7 @@ func readToken(token string, s string) (string, string) {
 }
 
 func readChar(c byte, s string) (bool, string) {
-	ss := strings.TrimSpace(s)
+	ss := strings.TrimLeftFunc(s, unicode.IsSpace)
 	if len(ss) > 0 && ss[0] == c {
 		return true, ss[1:]
 	}
@@ -43,53 +48,289 @@ func readQuote(s string) (bool, string) {
 	return readChar('\'', s)
 }
 
-func readSymbol(s string) (Value, string) {
-	//fmt.Println("Trying to read as symbol")
-	result, rest := readToken(`[^"'()#\s]+`, s)
-	if result == "" {
-		return nil, s
+// `x  ,x  ,@x  are short for (quasiquote x) (unquote x) (unquote-splicing x)
+// ,@ must come before ,
+
+var readerPrefixes = []struct{ token string; name string }{
+	{"`", "quasiquote"},
+	{",@", "unquote-splicing"},
+	{",", "unquote"},
+}
+
+func readPrefix(token string, s string) (bool, string) {
+	ss := strings.TrimLeftFunc(s, unicode.IsSpace)
+	if strings.HasPrefix(ss, token) {
+		return true, ss[len(token):]
 	}
-	return &VSymbol{result}, rest
+	return false, s
 }
 
-func readString(s string) (Value, string) {
+const symbolChars = `[^"'` + "`" + `,()\[\]#;\s]+`
+
+func readSymbol(s string) (Value, string) {
 	//fmt.Println("Trying to read as symbol")
-	result, rest := readToken(`"[^\n"]+"`, s)
+	result, rest := readToken(symbolChars, s)
 	if result == "" {
 		return nil, s
 	}
-	return &VString{result[1:len(result) - 1]}, rest
+	// keyword::foo is the same as :foo
+	if strings.HasPrefix(result, keywordModule + moduleSep) && len(result) > len(keywordModule + moduleSep) {
+		result = ":" + result[len(keywordModule + moduleSep):]
+	}
+	return intern(result), rest
 }
 
-func readInteger(s string) (Value, string) {
-	//fmt.Println("Trying to read as integer")
-	result, rest := readToken(`-?[0-9]+`, s)
-	if result == "" {
-		return nil, s
+// strings can span lines and contain escapes \" \\ \n \t \r \u{hex}
+
+func (r *Reader) readString(s string) (Value, string, error) {
+	ss := strings.TrimLeftFunc(s, unicode.IsSpace)
+	if !strings.HasPrefix(ss, "\"") {
+		return nil, s, nil
 	}
-	num, _ := strconv.Atoi(result)
-	return &VInteger{num}, rest
+	var result strings.Builder
+	i := 1
+	for i < len(ss) {
+		c := ss[i]
+		if c == '"' {
+			return &VString{result.String()}, ss[i + 1:], nil
+		}
+		if c != '\\' {
+			result.WriteByte(c)
+			i += 1
+			continue
+		}
+		if i + 1 >= len(ss) {
+			break
+		}
+		switch ss[i + 1] {
+		case '"', '\\':
+			result.WriteByte(ss[i + 1])
+		case 'n':
+			result.WriteByte('\n')
+		case 't':
+			result.WriteByte('\t')
+		case 'r':
+			result.WriteByte('\r')
+		case 'u':
+			end := strings.IndexByte(ss[i:], '}')
+			if !strings.HasPrefix(ss[i + 2:], "{") || end < 0 {
+				return nil, s, r.errorAt(ss[i:], "malformed \\u{...} escape in string")
+			}
+			code, err := strconv.ParseUint(ss[i + 3:i + end], 16, 32)
+			if err != nil || !utf8.ValidRune(rune(code)) {
+				return nil, s, r.errorAt(ss[i:], "invalid character code in \\u{...} escape")
+			}
+			result.WriteRune(rune(code))
+			i += end + 1
+			continue
+		default:
+			return nil, s, r.errorAt(ss[i:], "unknown escape \\" + string(ss[i + 1]) + " in string")
+		}
+		i += 2
+	}
+	return nil, s, r.errorAt(ss, "unterminated string")
 }
 
-func readBoolean(s string) (Value, string) {
-	// TODO: read all characters after # and then process
-	//       or treat # as a reader macro in some way?
-	result, rest := readToken(`#(?:t|T)`, s)
+// floats 1.5 1e10 -2.5e-3 +inf.0, rationals 1/3, and integers of any size
+
+func (r *Reader) readNumber(s string) (Value, string, error) {
+	result, rest := readToken(`(?:[-+]inf\.0|\+nan\.0)`, s)
+	if result != "" {
+		// as displayed by displayFloat
+		switch result {
+		case "+inf.0":
+			return &VFloat{math.Inf(1)}, rest, nil
+		case "-inf.0":
+			return &VFloat{math.Inf(-1)}, rest, nil
+		}
+		return &VFloat{math.NaN()}, rest, nil
+	}
+	result, rest = readToken(`-?[0-9]+(?:\.[0-9]+(?:[eE][-+]?[0-9]+)?|[eE][-+]?[0-9]+)`, s)
 	if result != "" {
-		return &VBoolean{true}, rest
+		num, err := strconv.ParseFloat(result, 64)
+		if err != nil {
+			return nil, s, r.errorAt(s, "float out of range " + result)
+		}
+		return &VFloat{num}, rest, nil
 	}
-	result, rest = readToken(`#(?:f|F)`, s)
+	result, rest = readToken(`-?[0-9]+/[0-9]+`, s)
 	if result != "" {
-		return &VBoolean{false}, rest
+		num, ok := new(big.Rat).SetString(result)
+		if !ok {
+			return nil, s, r.errorAt(s, "zero denominator in " + result)
+		}
+		return normalizeRat(num), rest, nil
+	}
+	result, rest = readToken(`-?[0-9]+`, s)
+	if result == "" {
+		return nil, s, nil
 	}
-	return nil, s
+	num, ok := new(big.Int).SetString(result, 10)
+	if !ok {
+		return nil, s, r.errorAt(s, "malformed integer " + result)
+	}
+	return normalizeBig(num), rest, nil
+}
+
+// reader macros #name and #name(args)
+// the arguments are read but not evaluated
+
+var readerMacros = []struct{ name string; hasArgs bool; fn func(Value) (Value, error) }{
+	{"t", false, func(args Value) (Value, error) { return &VBoolean{true}, nil }},
+	{"T", false, func(args Value) (Value, error) { return &VBoolean{true}, nil }},
+	{"f", false, func(args Value) (Value, error) { return &VBoolean{false}, nil }},
+	{"F", false, func(args Value) (Value, error) { return &VBoolean{false}, nil }},
+	{"nil", false, func(args Value) (Value, error) { return &VNil{}, nil }},
+	{"dict", true, readDict},
+	{"primitive", true, readPrimitive},
+}
+
+func readDict(args Value) (Value, error) {
+	content := map[*VSymbol]Value{}
+	for args.isCons() {
+		item := args.headValue()
+		if listLength(item) != 2 || !listLast(item).isEmpty() || !item.headValue().isSymbol() {
+			return nil, fmt.Errorf("expected (key value) in #dict - %s", item.display())
+		}
+		content[item.headValue().(*VSymbol)] = item.tailValue().headValue()
+		args = args.tailValue()
+	}
+	return &VDict{content}, nil
+}
+
+func readPrimitive(args Value) (Value, error) {
+	if listLength(args) != 1 || !args.headValue().isString() {
+		return nil, fmt.Errorf("expected #primitive(\"name\")")
+	}
+	prim, ok := primitiveTable[args.headValue().strValue()]
+	if !ok {
+		return nil, fmt.Errorf("no primitive named %s", args.headValue().strValue())
+	}
+	return prim, nil
+}
+
+func (r *Reader) readDispatch(s string) (Value, string, error) {
+	ss := strings.TrimLeftFunc(s, unicode.IsSpace)
+	if !strings.HasPrefix(ss, "#") {
+		return nil, s, nil
+	}
+	if strings.HasPrefix(ss, "#[") {
+		return r.readArray(ss)
+	}
+	name, rest := readToken(symbolChars, ss[1:])
+	for _, macro := range readerMacros {
+		if macro.name != name {
+			continue
+		}
+		var args Value = &VEmpty{}
+		if macro.hasArgs {
+			if !strings.HasPrefix(rest, "(") {
+				return nil, s, r.errorAt(rest, "expected arguments to #" + name)
+			}
+			var err error
+			args, rest, err = r.read(rest)
+			if err != nil {
+				return nil, s, err
+			}
+		}
+		result, err := macro.fn(args)
+		if err != nil {
+			return nil, s, r.errorAt(ss, err.Error())
+		}
+		return result, rest, nil
+	}
+	return nil, s, r.errorAt(ss, "unknown reader macro #" + name)
 }
 
-func readList(s string) (Value, string, error) {
+func (r *Reader) readArray(s string) (Value, string, error) {
+	content := make([]Value, 0)
+	rest, err := r.skip(s[2:])
+	if err != nil {
+		return nil, s, err
+	}
+	for !atListEnd(rest) && !strings.HasPrefix(rest, "]") {
+		var v Value
+		v, rest, err = r.read(rest)
+		if err != nil {
+			return nil, s, err
+		}
+		content = append(content, v)
+		rest, err = r.skip(rest)
+		if err != nil {
+			return nil, s, err
+		}
+	}
+	if !strings.HasPrefix(rest, "]") {
+		return nil, s, r.errorAt(s, "missing closing bracket")
+	}
+	return &VArray{content}, rest[1:], nil
+}
+
+// a reader records the span in the source of every value it reads
+// the strings passed to read() must all be suffixes of the source text
+
+// symbols are interned, so the span of an occurrence of a value in a
+// list is also recorded by the cell holding it
+
+type Reader struct {
+	source *Source
+	spans map[Value]*Span
+	heads map[Value]*Span     // the span of the head of each list cell
+}
+
+func newReader(name string, text string) *Reader {
+	return &Reader{&Source{name, text}, map[Value]*Span{}, map[Value]*Span{}}
+}
+
+func read(s string) (Value, string, error) {
+	return newReader("", s).read(s)
+}
+
+func (r *Reader) record(v Value, s string, rest string) {
+	text := r.source.text
+	trimmed := strings.TrimLeftFunc(s, unicode.IsSpace)
+	if len(trimmed) > len(text) || len(rest) > len(trimmed) {
+		return
+	}
+	r.spans[v] = &Span{r.source, len(text) - len(trimmed), len(text) - len(rest)}
+}
+
+func (r *Reader) readList(s string) (Value, string, error) {
 	var current *VCons
 	var result *VCons
-	expr, rest, err := read(s)
-	for err == nil {
+	rest, err := r.skip(s)
+	if err != nil {
+		return nil, s, err
+	}
+	for !atListEnd(rest) {
+		expr, next, err := r.read(rest)
+		if err != nil {
+			return nil, s, err
+		}
+		rest = next
+		if isDot(expr) {
+			// (a b . c) - the one expression after . is the tail
+			if current == nil {
+				return nil, s, r.errorAt(rest, "nothing before . in list")
+			}
+			rest, err = r.skip(rest)
+			if err != nil {
+				return nil, s, err
+			}
+			if atListEnd(rest) {
+				return nil, s, r.errorAt(rest, "nothing after . in list")
+			}
+			expr, rest, err = r.read(rest)
+			if err != nil {
+				return nil, s, err
+			}
+			rest, err = r.skip(rest)
+			if err != nil {
+				return nil, s, err
+			}
+			current.tail = expr
+			return result, rest, nil
+		}
 		if current == nil {
 			result = &VCons{head: expr, tail: &VEmpty{}}
 			current = result
@@ -98,7 +339,13 @@ func readList(s string) (Value, string, error) {
 			current.tail = temp
 			current = temp
 		}
-		expr, rest, err = read(rest)
+		if span, ok := r.spans[expr]; ok {
+			r.heads[current] = span
+		}
+		rest, err = r.skip(rest)
+		if err != nil {
+			return nil, s, err
+		}
 	}
 	if current == nil {
 		return &VEmpty{}, rest, nil
@@ -106,41 +353,132 @@ func readList(s string) (Value, string, error) {
 	return result, rest, nil
 }
 
-func read(s string) (Value, string, error) {
+// a list ends at a closing parenthesis, or at the end of the input
+// in which case the caller reports the missing parenthesis
+// comments must have been skipped already
+
+func atListEnd(s string) bool {
+	ss := strings.TrimLeftFunc(s, unicode.IsSpace)
+	return ss == "" || ss[0] == ')'
+}
+
+func isDot(v Value) bool {
+	return v.isSymbol() && v.strValue() == "."
+}
+
+func (r *Reader) read(s string) (Value, string, error) {
+	ss, err := r.skip(s)
+	if err != nil {
+		return nil, s, err
+	}
+	v, rest, err := r.readValue(ss)
+	if err == nil && v != nil {
+		r.record(v, ss, rest)
+	}
+	return v, rest, err
+}
+
+// skip whitespace and comments:
+//   ; to the end of the line
+//   #| ... |# which can nest
+//   #; followed by a datum
+
+func (r *Reader) skip(s string) (string, error) {
+	for {
+		s = strings.TrimLeftFunc(s, unicode.IsSpace)
+		switch {
+		case strings.HasPrefix(s, ";"):
+			end := strings.IndexByte(s, '\n')
+			if end < 0 {
+				return "", nil
+			}
+			s = s[end + 1:]
+		case strings.HasPrefix(s, "#|"):
+			depth := 0
+			i := 0
+			for {
+				if i + 1 >= len(s) {
+					return s, r.errorAt(s, "unterminated block comment")
+				}
+				if s[i:i + 2] == "#|" {
+					depth += 1
+					i += 2
+				} else if s[i:i + 2] == "|#" {
+					depth -= 1
+					i += 2
+					if depth == 0 {
+						break
+					}
+				} else {
+					i += 1
+				}
+			}
+			s = s[i:]
+		case strings.HasPrefix(s, "#;"):
+			_, rest, err := r.read(s[2:])
+			if err != nil {
+				return s, err
+			}
+			s = rest
+		default:
+			return s, nil
+		}
+	}
+}
+
+// true if only whitespace and comments are left
+
+func (r *Reader) atEnd(s string) bool {
+	ss, err := r.skip(s)
+	return err == nil && ss == ""
+}
+
+func (r *Reader) readValue(s string) (Value, string, error) {
 	//fmt.Println("Trying to read string", s)
 	var resultB bool
 	var rest string
 	var result Value
 	var err error
-	result, rest = readInteger(s)
-	if result != nil {
-		return result, rest, nil
+	result, rest, err = r.readNumber(s)
+	if err != nil || result != nil {
+		return result, rest, err
 	}
 	result, rest = readSymbol(s)
 	if result != nil {
 		return result, rest, nil
 	}
-	result, rest = readString(s)
-	if result != nil {
-		return result, rest, nil
+	result, rest, err = r.readString(s)
+	if err != nil || result != nil {
+		return result, rest, err
 	}
-	result, rest = readBoolean(s)
-	if result != nil {
-		return result, rest, nil
+	result, rest, err = r.readDispatch(s)
+	if err != nil || result != nil {
+		return result, rest, err
 	}
 	resultB, rest = readQuote(s)
 	if resultB {
 		var expr Value
-		expr, rest, err = read(rest)
+		expr, rest, err = r.read(rest)
 		if err != nil {
 			return nil, s, err
 		}
-		return &VCons{head: &VSymbol{"quote"}, tail: &VCons{head: expr, tail: &VEmpty{}}}, rest, nil
+		return &VCons{head: intern("quote"), tail: &VCons{head: expr, tail: &VEmpty{}}}, rest, nil
+	}
+	for _, prefix := range readerPrefixes {
+		resultB, rest = readPrefix(prefix.token, s)
+		if resultB {
+			var expr Value
+			expr, rest, err = r.read(rest)
+			if err != nil {
+				return nil, s, err
+			}
+			return &VCons{head: intern(prefix.name), tail: &VCons{head: expr, tail: &VEmpty{}}}, rest, nil
+		}
 	}
 	resultB, rest = readLP(s)
 	if resultB {
 		var exprs Value
-		exprs, rest, err = readList(rest)
+		exprs, rest, err = r.readList(rest)
 		if err != nil {
 			return nil, s, err
 		}
@@ -149,10 +487,20 @@ func read(s string) (Value, string, error) {
 		}
 		resultB, rest = readRP(rest)
 		if !resultB {
-			return nil, s, errors.New("missing closing parenthesis")
+			return nil, s, r.errorAt(s, "missing closing parenthesis")
 		}
 		return exprs, rest, nil
 	}
 	//return nil, s, nil
-	return nil, s, errors.New("Cannot read input")
+	return nil, s, r.errorAt(s, "Cannot read input")
+}
+
+func (r *Reader) errorAt(s string, msg string) error {
+	text := r.source.text
+	trimmed := strings.TrimLeftFunc(s, unicode.IsSpace)
+	if len(trimmed) > len(text) {
+		return errors.New(msg)
+	}
+	start := len(text) - len(trimmed)
+	return &LocatedError{&Span{r.source, start, start}, errors.New(msg)}
 }
@@ -5,88 +5,387 @@ import "bufio"
 import "os"
 import "strings"
 import "io"
+import "unicode"
 
-var context = Context{"", "", nil}
+var context = Context{"", "", nil, nil}
 
-func shell(eco *Ecosystem) {
-	env := eco.mkEnv("*scratch*", map[string]Value{})
-	context.currentModule = "*scratch*"
-	context.nextCurrentModule = "*scratch*"
+const scratchModule = "*scratch*"
+
+func startScratch(eco *Ecosystem) *Env {
+	eco.mkEnv(scratchModule, map[string]Value{})
+	context.currentModule = scratchModule
+	context.nextCurrentModule = scratchModule
 	context.ecosystem = eco
+	// the shell works in the same layer as any module it switches to
+	return eco.activesEnv[scratchModule]
+}
+
+func shell(eco *Ecosystem) {
+	env := startScratch(eco)
 	reader := bufio.NewReader(os.Stdin)
 	showModules(env)
+	// comments entered on their own are kept with the next form
+	comments := ""
 	for {
-		if context.nextCurrentModule != context.currentModule {
-			current := context.currentModule
-			context.currentModule = context.nextCurrentModule
-			new_env, err := eco.get(context.currentModule)
-			if err != nil {
-				// reset the module names
-				context.currentModule = current
-				context.nextCurrentModule = current
-				fmt.Println("ERROR -", err.Error())
-			} else {
-				env = new_env
-			}
-		}
-		fmt.Printf("%s> ", context.currentModule)
-		text, err := reader.ReadString('\n')
+		env = switchModule(eco, env)
+		text, err := readInput(reader)
 		if err != nil {
 			if err == io.EOF {
 				fmt.Println()
 				bail()
 			}
-			fmt.Println("IO ERROR - ", err.Error())
+			fmt.Fprintln(os.Stderr, "IO ERROR - ", err.Error())
+			continue
 		}
-		if strings.TrimSpace(text) == "" {
+		// there may be several forms on the line
+		reader := newReader("", text)
+		if reader.atEnd(text) {
+			comments += strings.TrimSpace(text) + "\n"
 			continue
 		}
-		v, _, err := read(text)
-		if err != nil {
-			fmt.Println("READ ERROR -", err.Error())
+		for !reader.atEnd(text) {
+			v, rest, err := reader.read(text)
+			if err != nil {
+				reportError("READ", err)
+				break
+			}
+			source := comments + strings.TrimSpace(text[:len(text) - len(rest)])
+			comments = ""
+			text = rest
+			result, ok := processForm(eco, reader, v, source, context.currentModule, env)
+			if ok && result != nil && !result.isNil() {
+				fmt.Println(result.display())
+			}
+			env = switchModule(eco, env)
+		}
+	}
+}
+
+func switchModule(eco *Ecosystem, env *Env) *Env {
+	if context.nextCurrentModule == context.currentModule {
+		return env
+	}
+	current := context.currentModule
+	context.currentModule = context.nextCurrentModule
+	new_env, err := eco.get(context.currentModule)
+	if err != nil {
+		// reset the module names
+		context.currentModule = current
+		context.nextCurrentModule = current
+		fmt.Fprintln(os.Stderr, "ERROR -", err.Error())
+		return env
+	}
+	return new_env
+}
+
+// read lines until parentheses and strings are balanced
+
+func readInput(reader *bufio.Reader) (string, error) {
+	prompt := fmt.Sprintf("%s> ", context.currentModule)
+	full := ""
+	for {
+		fmt.Print(prompt)
+		text, err := reader.ReadString('\n')
+		if err != nil && (err != io.EOF || strings.TrimSpace(full + text) == "") {
+			return "", err
+		}
+		full += text
+		if strings.TrimSpace(full) != "" && balanced(full) {
+			return full, nil
+		}
+		if err == io.EOF {
+			// whatever we have, we return it and let read() complain
+			return full, nil
+		}
+		if strings.TrimSpace(full) != "" {
+			// use the continuation prompt after the first line
+			prompt = strings.Repeat(".", len(context.currentModule)) + "  "
+		}
+	}
+}
+
+func balanced(s string) bool {
+	count := 0
+	inString := false
+	escape := false
+	inComment := false
+	blockDepth := 0
+	// a #; still waiting for its datum
+	pendingDatum := false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if inString {
+			if escape {
+				escape = false
+			} else if c == '\\' {
+				escape = true
+			} else if c == '"' {
+				inString = false
+			}
 			continue
 		}
-		// check if it's a declaration
-		d, err := parseDef(v)
-		if err != nil { 
-			fmt.Println("PARSE ERROR -", err.Error())
+		if inComment {
+			inComment = (c != '\n')
 			continue
 		}
-		if d != nil {
-			if d.typ == DEF_FUNCTION { 
-				env.update(d.name, &VFunction{d.params, d.body, env})
-				fmt.Println(d.name)
-				continue
-			}
-			if d.typ == DEF_VALUE {
-				v, err := d.body.eval(env)
-				if err != nil {
-					fmt.Println("EVAL ERROR -", err.Error())
-					continue
-				}
-				env.update(d.name, v)
-				fmt.Println(d.name)
-				continue
+		if strings.HasPrefix(s[i:], "#|") {
+			blockDepth += 1
+			i += 1
+			continue
+		}
+		if blockDepth > 0 {
+			if strings.HasPrefix(s[i:], "|#") {
+				blockDepth -= 1
+				i += 1
 			}
-			fmt.Println("DECLARE ERROR - unknow declaration type", d.typ)
 			continue
 		}
-		// check if it's an expression
-		e, err := parseExpr(v)
-		if err != nil { 
-			fmt.Println("PARSE ERROR -", err.Error())
+		if strings.HasPrefix(s[i:], "#;") {
+			pendingDatum = true
+			i += 1
 			continue
 		}
-		///fmt.Println("expr =", e.str())
-		v, err = e.eval(env)
+		if c != ';' && !unicode.IsSpace(rune(c)) {
+			pendingDatum = false
+		}
+		switch c {
+		case '(':
+			count += 1
+		case ')':
+			count -= 1
+		case '"':
+			inString = true
+		case ';':
+			inComment = true
+		}
+	}
+	return count <= 0 && !inString && blockDepth == 0 && !pendingDatum
+}
+
+// process a top-level form in a module, reporting errors as we go
+// returns the value of the form if it is an expression
+
+func processForm(eco *Ecosystem, reader *Reader, v Value, source string, module string, env *Env) (Value, bool) {
+	d, e, err := newParser(reader, env).parseTop(v)
+	if err != nil { 
+		reportError("PARSE", err)
+		return nil, false
+	}
+	// check if it's a declaration
+	if d != nil {
+		// saved definitions go into the module itself, not the shell layer
+		defEnv := env
+		if eco.isPersistent(module) {
+			defEnv = eco.modulesEnv[module]
+		}
+		snap := eco.snapshot(defEnv, d.name)
+		if err := evalDef(d, defEnv); err != nil {
+			reportError("EVAL", err)
+			return nil, false
+		}
+		if entry, ok := eco.index.entries[Ref{defEnv.module, d.name}]; ok && !eco.isPersistent(module) {
+			entry.source = source
+		}
+		// the definitions using this one may be broken by the change
+		problems := 0
+		for _, name := range eco.changedNames(defEnv, snap) {
+			for _, err := range eco.checkDependents(Ref{defEnv.module, name}) {
+				reportError("CHECK", err)
+				problems++
+			}
+		}
+		// a definition that breaks its users is undone
+		if problems > 0 {
+			eco.restore(defEnv, d.name, snap)
+			fmt.Fprintln(os.Stderr, "DEF ERROR -", d.name, "not defined, fix its users first")
+			return nil, false
+		}
+		if err := eco.saveSource(module, d.name, source); err != nil {
+			fmt.Fprintln(os.Stderr, "SAVE ERROR -", err.Error())
+		}
+		// on stderr, so as not to mix with the output of scripts
+		fmt.Fprintln(os.Stderr, d.name)
+		return nil, true
+	}
+	v, err = e.eval(env)
+	if err != nil {
+		reportError("EVAL", err)
+		return nil, false
+	}
+	return v, true
+}
+
+// load every top-level form of a file into the current module
+// returns the number of forms that failed
+
+func loadFile(eco *Ecosystem, filename string) (int, error) {
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return 0, err
+	}
+	module := context.currentModule
+	env, err := eco.get(module)
+	if err != nil {
+		return 0, err
+	}
+	failed := 0
+	text := string(content)
+	reader := newReader(filename, text)
+	for !reader.atEnd(text) {
+		v, rest, err := reader.read(text)
+		if err != nil {
+			// we can't recover from a read error
+			return failed + 1, err
+		}
+		source := strings.TrimSpace(text[:len(text) - len(rest)])
+		text = rest
+		if _, ok := processForm(eco, reader, v, source, module, env); !ok {
+			failed += 1
+		}
+		// the file may switch modules
+		if context.nextCurrentModule != module {
+			new_env, err := eco.get(context.nextCurrentModule)
+			if err != nil {
+				return failed + 1, err
+			}
+			module = context.nextCurrentModule
+			env = new_env
+		}
+	}
+	return failed, nil
+}
+
+// run a file from the command line
+
+func script(eco *Ecosystem, filename string, args []string) {
+	startScratch(eco)
+	var argList Value = &VEmpty{}
+	for i := len(args) - 1; i >= 0; i-- {
+		argList = &VCons{head: &VString{args[i]}, tail: argList}
+	}
+	eco.modulesEnv["config"].update("args", argList)
+	failed, err := loadFile(eco, filename)
+	if err != nil {
+		reportError("LOAD", err)
+		os.Exit(1)
+	}
+	if failed > 0 {
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+// print an error on stderr, along with where it happened if we know
+
+func reportError(kind string, err error) {
+	fmt.Fprintf(os.Stderr, "%s ERROR - %s\n", kind, err.Error())
+	span := errorSpan(err)
+	if span != nil {
+		fmt.Fprintln(os.Stderr, "  at", span.str())
+		for _, line := range strings.Split(span.excerpt(), "\n") {
+			fmt.Fprintln(os.Stderr, "    " + line)
+		}
+	}
+	if kind != "EVAL" && kind != "LOAD" {
+		return
+	}
+	// keep it around for (last-error)
+	rerr := toRuntimeError(err)
+	context.lastError = rerr
+	for _, frame := range rerr.trace {
+		fmt.Fprintln(os.Stderr, "  in", frame.str())
+	}
+}
+
+func evalDef(d *Def, env *Env) error {
+	if d.typ == DEF_FUNCTION {
+		if err := env.define(d.name, &VFunction{d.params, d.body, env, d.name}, d.typ); err != nil {
+			return locate(err, d.span)
+		}
+		env.ecosystem.index.add(d, env)
+		return nil
+	}
+	if d.typ == DEF_MACRO {
+		if err := env.define(d.name, &VMacro{&VFunction{d.params, d.body, env, d.name}}, d.typ); err != nil {
+			return locate(err, d.span)
+		}
+		env.ecosystem.index.add(d, env)
+		return nil
+	}
+	if d.typ == DEF_HEADER {
+		if d.header.name != env.module {
+			return locate(fmt.Errorf("module-header for %s in module %s", d.header.name, env.module), d.span)
+		}
+		env.ecosystem.headers[env.module] = d.header
+		return nil
+	}
+	if d.typ == DEF_TYPE {
+		if old := env.boundType(d.rtype.name); old != nil {
+			env.unbindType(old)
+		}
+		names, values := d.rtype.bindings()
+		for i, name := range names {
+			if err := env.define(name, values[i], d.typ); err != nil {
+				return locate(err, d.span)
+			}
+		}
+		return nil
+	}
+	if d.typ == DEF_CLASS {
+		if err := env.define(d.name, d.class.constructor(env), d.typ); err != nil {
+			return locate(err, d.span)
+		}
+		env.ecosystem.index.add(d, env)
+		return nil
+	}
+	if d.typ == DEF_GENERIC {
+		var dflt Value
+		if d.body != nil {
+			dflt = &VFunction{d.params, d.body, env, d.name}
+		}
+		if g, ok := env.bindings[d.name].(*VGeneric); ok {
+			// redefining a generic function keeps its methods
+			g.params = d.params
+			g.dflt = dflt
+		} else if err := env.define(d.name, &VGeneric{d.name, d.params, []*Method{}, dflt}, d.typ); err != nil {
+			return locate(err, d.span)
+		}
+		env.ecosystem.index.add(d, env)
+		return nil
+	}
+	if d.typ == DEF_METHOD {
+		v, err := env.find(d.method.generic)
 		if err != nil {
-			fmt.Println("EVAL ERROR -", err.Error())
-			continue
+			return locate(err, d.span)
+		}
+		g, ok := v.(*VGeneric)
+		if !ok {
+			return locate(newError(ERR_TYPE, v, "%s is not a generic function", d.method.generic), d.span)
 		}
-		if !v.isNil() { 
-			fmt.Println(v.display())
+		for _, spec := range d.method.specs {
+			if spec != anyType && !env.isTypeName(spec) {
+				return locate(newError(ERR_TYPE, intern(spec), "unknown type %s in method for %s", spec, d.method.generic), d.span)
+			}
 		}
+		if len(d.method.specs) != len(g.params.required) {
+			return locate(newError(ERR_ARITY, v, "method for %s must have %d parameters before its rest parameter", d.method.generic, len(g.params.required)), d.span)
+		}
+		g.addMethod(d.method.specs, &VFunction{d.params, d.body, env, d.name})
+		env.ecosystem.index.add(d, env)
+		return nil
 	}
+	if d.typ == DEF_VALUE || d.typ == DEF_CONST || d.typ == DEF_VAR {
+		v, err := d.body.eval(env)
+		if err != nil {
+			return locate(err, d.span)
+		}
+		if err := env.define(d.name, v, d.typ); err != nil {
+			return locate(err, d.span)
+		}
+		env.ecosystem.index.add(d, env)
+		return nil
+	}
+	return fmt.Errorf("unknown declaration type %d", d.typ)
 }
 
 func bail() {
@@ -94,7 +393,29 @@ func bail() {
 	os.Exit(0)
 }
 
-func initialize() *Ecosystem {
+// scripts don't create a store, but use one that exists
+
+func initialize(createStorage bool) *Ecosystem {
+	eco := initializeModules()
+	if !createStorage && !storageExists(sourcePath) {
+		return eco
+	}
+	storage, err := mkStorage(sourcePath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "STORAGE ERROR -", err.Error())
+		return eco
+	}
+	if err := eco.loadModules(storage); err != nil {
+		fmt.Fprintln(os.Stderr, "STORAGE ERROR -", err.Error())
+		return eco
+	}
+	eco.storage = storage
+	return eco
+}
+
+// the builtin modules, without any storage
+
+func initializeModules() *Ecosystem {
 	eco := mkEcosystem()
 	coreBindings := corePrimitives()
 	coreBindings["true"] = &VBoolean{true}
@@ -103,7 +424,7 @@ func initialize() *Ecosystem {
 	testBindings := map[string]Value{
 		"a": &VInteger{99},
 		"square": &VPrimitive{"square", func(args []Value) (Value, error) {
-			if len(args) != 1 || !args[0].isNumber() {
+			if len(args) != 1 || !isInt(args[0]) {
 				return nil, fmt.Errorf("argument to square should be int")
 			}
 			return &VInteger{args[0].intValue() * args[0].intValue()}, nil
@@ -113,8 +434,9 @@ func initialize() *Ecosystem {
 	shellBindings := shellPrimitives()
 	eco.mkEnv("shell", shellBindings)
 	configBindings := map[string]Value{
-		"lookup-path": &VReference{&VCons{head: &VSymbol{"shell"}, tail: &VCons{head: &VSymbol{"core"}, tail: &VEmpty{}}}},
+		"lookup-path": &VReference{&VCons{head: intern("shell"), tail: &VCons{head: intern("core"), tail: &VEmpty{}}}},
 		"editor": &VReference{&VString{"emacs"}},
+		"args": &VEmpty{},
 	}
 	eco.mkEnv("config", configBindings)
 	return eco
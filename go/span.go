@@ -0,0 +1,104 @@
+package main
+
+import "fmt"
+import "strings"
+import "errors"
+import "unicode/utf8"
+
+// Source positions
+//
+// A span is a range of byte offsets into a source text.
+// Lines and columns are computed on demand, both starting at 1.
+
+type Source struct {
+	name string
+	text string
+}
+
+type Span struct {
+	source *Source
+	start int
+	end int
+}
+
+func (sp *Span) position() (int, int) {
+	text := sp.source.text[:min(sp.start, len(sp.source.text))]
+	line := strings.Count(text, "\n") + 1
+	lineStart := strings.LastIndex(text, "\n") + 1
+	col := utf8.RuneCountInString(text[lineStart:]) + 1
+	return line, col
+}
+
+func (sp *Span) str() string {
+	line, col := sp.position()
+	name := sp.source.name
+	if name == "" {
+		name = "<input>"
+	}
+	return fmt.Sprintf("%s:%d:%d", name, line, col)
+}
+
+// the line of source containing the start of the span, with a caret under it
+
+func (sp *Span) excerpt() string {
+	text := sp.source.text
+	start := min(sp.start, len(text))
+	lineStart := strings.LastIndex(text[:start], "\n") + 1
+	lineEnd := strings.Index(text[start:], "\n")
+	if lineEnd < 0 {
+		lineEnd = len(text)
+	} else {
+		lineEnd += start
+	}
+	line := strings.TrimRight(text[lineStart:lineEnd], " \t\r")
+	caret := ""
+	for _, c := range text[lineStart:start] {
+		if c == '\t' {
+			caret += "\t"
+		} else {
+			caret += " "
+		}
+	}
+	return line + "\n" + caret + "^"
+}
+
+// an error that knows where in the source it happened
+
+type LocatedError struct {
+	span *Span
+	err error
+}
+
+func (e *LocatedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *LocatedError) Unwrap() error {
+	return e.err
+}
+
+// attach a span to an error, unless it already has one
+
+func locate(err error, span *Span) error {
+	if err == nil || span == nil || errorSpan(err) != nil {
+		return err
+	}
+	var rerr *RuntimeError
+	if errors.As(err, &rerr) {
+		rerr.span = span
+		return err
+	}
+	return &LocatedError{span, err}
+}
+
+func errorSpan(err error) *Span {
+	var located *LocatedError
+	if errors.As(err, &located) {
+		return located.span
+	}
+	var rerr *RuntimeError
+	if errors.As(err, &rerr) {
+		return rerr.span
+	}
+	return nil
+}
@@ -2,7 +2,11 @@ package main
 
 import "fmt"
 
-func test() {
+// the number of failed checks
+
+var testFailures = 0
+
+func test() int {
 
 	test_value_10()
 	test_value_plus()
@@ -12,6 +16,19 @@ func test() {
 	test_if()
 	test_lists()
 	test_read()
+	test_spans()
+	test_rest_bodies()
+	test_method_calls()
+	test_string_sequences()
+	test_handlers()
+	test_gensym_macros()
+	test_match()
+	test_strings()
+	test_numbers()
+	test_dependencies()
+	test_dispatch()
+	fmt.Println(testFailures, "failed")
+	return testFailures
 }
 
 func primitiveAdd(args []Value) (Value, error) {
@@ -65,35 +82,35 @@ func evalDisplay(e AST, env *Env) string {
 
 func test_literal() {
 	v1 := &VInteger{10}
-	e1 := &Literal{v1}
+	e1 := &Literal{v1, nil}
 	fmt.Println(e1.str(), "->", evalDisplay(e1, nil))
 	v2 := &VBoolean{true}
-	e2 := &Literal{v2}
+	e2 := &Literal{v2, nil}
 	fmt.Println(e2.str(), "->", evalDisplay(e2, nil))
 }
 
 func test_lookup() {
 	env := sampleEnv()
-	e1 := &Id{"a"}
+	e1 := &Id{"a", nil}
 	fmt.Println(e1.str(), "->", evalDisplay(e1, env))
-	e2 := &Id{"+"}
+	e2 := &Id{"+", nil}
 	fmt.Println(e2.str(), "->", evalDisplay(e2, env))
 }
 
 func test_apply() {
 	env := sampleEnv()
-	e1 := &Id{"a"}
-	e2 := &Id{"b"}
+	e1 := &Id{"a", nil}
+	e2 := &Id{"b", nil}
 	args := []AST{e1, e2}
-	e3 := &Apply{&Id{"+"}, args}
+	e3 := &Apply{&Id{"+", nil}, args, nil}
 	fmt.Println(e3.str(), "->", evalDisplay(e3, env))
 }
 
 func test_if() {
 	env := sampleEnv()
-	e1 := &If{&Id{"t"}, &Id{"a"}, &Id{"b"}}
+	e1 := &If{&Id{"t", nil}, &Id{"a", nil}, &Id{"b", nil}, nil}
 	fmt.Println(e1.str(), "->", evalDisplay(e1, env))
-	e2 := &If{&Id{"f"}, &Id{"a"}, &Id{"b"}}
+	e2 := &If{&Id{"f", nil}, &Id{"a", nil}, &Id{"b", nil}, nil}
 	fmt.Println(e2.str(), "->", evalDisplay(e2, env))
 }
 
@@ -119,3 +136,240 @@ func test_lists() {
 	v = &VCons{head: &VInteger{99}, tail: v}
 	fmt.Println(v.str(), "->", v.display())
 }
+
+// each occurrence of a symbol gets its own span even though symbols
+// are interned
+
+func test_spans() {
+	r := newReader("", "(+ zz\n zz)")
+	v, _, _ := r.read(r.source.text)
+	e, _ := newParser(r, nil).parseExpr(v)
+	args := e.(*Apply).args
+	for i, expected := range []string{"<input>:1:4", "<input>:2:2"} {
+		got := args[i].getSpan().str()
+		if got != expected {
+			fmt.Println("FAILED span of", args[i].str(), "->", got, "expected", expected)
+			testFailures++
+			continue
+		}
+		fmt.Println(args[i].str(), "->", got)
+	}
+}
+
+// evaluate every form of src in a fresh scratch module, returning the
+// value of the last one
+
+func evalSource(src string) (Value, error) {
+	return evalIn(startScratch(initializeModules()), src)
+}
+
+func evalIn(env *Env, src string) (Value, error) {
+	r := newReader("", src)
+	var result Value = &VNil{}
+	for text := src; !r.atEnd(text); {
+		v, rest, err := r.read(text)
+		if err != nil {
+			return nil, err
+		}
+		text = rest
+		d, e, err := newParser(r, env).parseTop(v)
+		if err != nil {
+			return nil, err
+		}
+		if d != nil {
+			if err := evalDef(d, env); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		result, err = e.eval(env)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+func checkSource(src string, expected string) {
+	got := ""
+	v, err := evalSource(src)
+	if err != nil {
+		got = "ERROR " + err.Error()
+	} else {
+		got = v.display()
+	}
+	if got != expected {
+		fmt.Println("FAILED", src, "->", got, "expected", expected)
+		testFailures++
+		return
+	}
+	fmt.Println(src, "->", got)
+}
+
+// a rest parameter followed by several body forms is not a recursive
+// function
+
+func test_rest_bodies() {
+	checkSource("((fn args (list args) (length args)) 1 2)", "2")
+	checkSource("((fn xs (head xs) (tail xs)) 1 2)", "(2)")
+	checkSource("((funrec fact (n) (if (= n 0) 1 (* n (fact (- n 1))))) 5)", "120")
+}
+
+// a local binding beats a method of the same name
+
+func test_method_calls() {
+	box := "(class box (v) (field x v) (def (size) (field-get this x))) "
+	checkSource(box + "(size (box 5))", "5")
+	checkSource(box + "(let ((size (fn (o) 0))) (size (box 5)))", "0")
+	checkSource(box + "(def (size o) -1) (list (size (box 5)) (size 3))", "(5 -1)")
+}
+
+// strings are sequences of characters, not bytes
+
+func test_string_sequences() {
+	checkSource(`(length "été")`, "3")
+	checkSource(`(reverse "été")`, `"été"`)
+	checkSource(`(length (reverse "aé"))`, "2")
+}
+
+// handlers always get an error value
+
+func test_handlers() {
+	checkSource("(try (raise 42) (catch user e (error-value e)))", "42")
+	checkSource("(try (raise 42) (catch-if error? e (error-kind e)))", "user")
+	checkSource("(try (head '()) (catch-if error? e (error-kind e)))", "index")
+}
+
+// a macro binding a name made with gensym doesn't capture the caller's
+
+func test_gensym_macros() {
+	myOr := "(macro (my-or a b) (let ((tmp (gensym))) `(let ((,tmp ,a)) (if ,tmp ,tmp ,b)))) "
+	checkSource(myOr + "(let ((tmp 5)) (my-or #f tmp))", "5")
+	checkSource(myOr + "(my-or 1 (head '()))", "1")
+}
+
+func test_match() {
+	checkSource("(match '(1 2 3) ((a . rest) rest))", "(2 3)")
+	checkSource("(match '(1 2) ((a b c) 'three) ((a b) (+ a b)))", "3")
+	checkSource("(match 5 (x when (> x 10) 'big) (x 'small))", "small")
+	checkSource("(match :k (:j 1) (:k 2))", "2")
+	checkSource("(match 'foo ('bar 1) ('foo 2))", "2")
+	checkSource("(match #[1 2 3] ((array a . more) more))", "#[2 3]")
+	checkSource("(match #dict((a 1) (b 2)) ((dict (b x)) x))", "2")
+	checkSource("(deftype shape (circle r) (rect w h)) (match (rect 2 3) ((circle r) r) ((rect w h) (* w h)))", "6")
+	checkSource("(try (match 1 (2 'two)) (catch match e 'none))", "none")
+}
+
+// string literals read to the expected text, and print back to
+// something that reads the same
+
+func test_strings() {
+	cases := [][]string{
+		{`""`, ""},
+		{`"a\"b"`, `a"b`},
+		{`"back\\slash"`, `back\slash`},
+		{`"x\ny\tz"`, "x\ny\tz"},
+		{`"\u{e9}t\u{E9}"`, "été"},
+		{"\"two\nlines\"", "two\nlines"},
+	}
+	for _, c := range cases {
+		v, _, err := read(c[0])
+		if err != nil || !v.isString() || v.strValue() != c[1] {
+			fmt.Println("FAILED read", c[0], "->", v, err, "expected", c[1])
+			testFailures++
+			continue
+		}
+		again, _, err := read(v.display())
+		if err != nil || again.strValue() != c[1] {
+			fmt.Println("FAILED round trip of", c[0], "->", v.display())
+			testFailures++
+			continue
+		}
+		fmt.Println(c[0], "->", v.display())
+	}
+	if _, _, err := read(`"unterminated`); err == nil {
+		fmt.Println("FAILED unterminated string reads")
+		testFailures++
+	}
+}
+
+// overflow promotes to big integers, and exact results come back down
+// to the simplest representation
+
+func test_numbers() {
+	checkSource("(+ 9223372036854775807 1)", "9223372036854775808")
+	checkSource("(- -9223372036854775808 1)", "-9223372036854775809")
+	checkSource("(* 9223372036854775807 2)", "18446744073709551614")
+	checkSource("(- -9223372036854775808)", "9223372036854775808")
+	checkSource("(* -9223372036854775808 -1)", "9223372036854775808")
+	checkSource("(quotient -9223372036854775808 -1)", "9223372036854775808")
+	checkSource("(expt 2 100)", "1267650600228229401496703205376")
+	checkSource("(/ 1 3)", "1/3")
+	checkSource("(+ 1/3 2/3)", "1")
+	checkSource("(+ 1/2 0.5)", "1.0")
+	for _, src := range []string{"(- (+ 9223372036854775807 1) 1)", "(/ 4 2)", "(+ 1/3 2/3)"} {
+		v, err := evalSource(src)
+		if _, ok := v.(*VInteger); err != nil || !ok {
+			fmt.Println("FAILED", src, "is not a small int")
+			testFailures++
+			continue
+		}
+		fmt.Println(src, "-> small int")
+	}
+}
+
+// the index follows definitions, and changing one re-checks its users
+
+func test_dependencies() {
+	defs := "(def (f x) x) (def (g) (f 1)) (def (h) (g)) "
+	checkSource(defs + "(dependencies 'h)", "(*scratch*::g)")
+	checkSource(defs + "(references 'f)", "(*scratch*::g)")
+	checkSource(defs + "(all-references 'f)", "(*scratch*::g *scratch*::h)")
+	checkSource(defs + "(all-dependencies 'h)", "(*scratch*::f *scratch*::g)")
+	env := startScratch(initializeModules())
+	eco := env.ecosystem
+	cases := []struct {
+		src string
+		problems int
+	}{
+		{defs, 0},
+		{"(def (f x y) x)", 1},
+		{"(def (f x (y 0)) x)", 0},
+		{"(def (g) 2)", 0},
+	}
+	for _, c := range cases {
+		if _, err := evalIn(env, c.src); err != nil {
+			fmt.Println("FAILED", c.src, "->", err)
+			testFailures++
+			continue
+		}
+		problems := 0
+		for _, name := range []string{"f", "g"} {
+			problems += len(eco.checkDependents(Ref{scratchModule, name}))
+		}
+		if problems != c.problems {
+			fmt.Println("FAILED", c.src, "->", problems, "problems, expected", c.problems)
+			testFailures++
+			continue
+		}
+		fmt.Println(c.src, "->", problems, "problems")
+	}
+}
+
+// the most specific method wins, from left to right, a variant being
+// more specific than its type
+
+func test_dispatch() {
+	gen := "(deftype shape (circle r) (rect w h)) (defgeneric (meet a b) 'default) " +
+		"(defmethod (meet a (b int)) 'any-int) (defmethod (meet (a int) b) 'int-any) " +
+		"(defmethod (meet (a shape) b) 'shape) (defmethod (meet (a circle) b) 'circle) "
+	checkSource(gen + "(meet 1 2)", "int-any")
+	checkSource(gen + "(meet \"x\" 2)", "any-int")
+	checkSource(gen + "(meet \"x\" \"y\")", "default")
+	checkSource(gen + "(meet (circle 1) 2)", "circle")
+	checkSource(gen + "(meet (rect 1 2) 2)", "shape")
+	checkSource(gen + "(defmethod (meet (a int) b) 'replaced) (meet 1 2)", "replaced")
+	checkSource(gen + "(defmethod (meet (a circel) b) 1)", "ERROR unknown type circel in method for meet")
+	checkSource("(defgeneric (size s)) (try (size 1) (catch type e 'no-method))", "no-method")
+	checkSource("(defmethod (length (s symbol)) 1) (list (length 'a) (length '(1 2)))", "(1 2)")
+}
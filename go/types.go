@@ -0,0 +1,145 @@
+package main
+
+import "fmt"
+import "strings"
+
+// User-defined types
+//
+// (deftype point x y)                      a product type
+// (deftype shape (circle r) (rect w h))    a sum type
+//
+// A product type is a sum type with a single variant named after the
+// type. Each variant gets a constructor, a predicate variant?, field
+// accessors variant-field, and functional updates variant-with-field
+// returning a copy with one field replaced. A sum type also gets a
+// predicate type? that holds for all its variants.
+
+type RecordType struct {
+	name string
+	variants []*Variant
+}
+
+type Variant struct {
+	name string
+	fields []string
+	typ *RecordType
+}
+
+func (t *RecordType) isProduct() bool {
+	return len(t.variants) == 1 && t.variants[0].name == t.name
+}
+
+func (t *RecordType) str() string {
+	variants := make([]string, len(t.variants))
+	for i, vr := range t.variants {
+		variants[i] = vr.name + "[" + strings.Join(vr.fields, " ") + "]"
+	}
+	return fmt.Sprintf("RecordType[%s %s]", t.name, strings.Join(variants, " "))
+}
+
+// the names and values that deftype binds, in order
+
+func (t *RecordType) bindings() ([]string, []Value) {
+	names := []string{}
+	values := []Value{}
+	add := func(name string, v Value) {
+		names = append(names, name)
+		values = append(values, v)
+	}
+	if !t.isProduct() {
+		add(t.name + "?", mkTypePredicate(t.name + "?", func(r *VRecord) bool {
+			return r.variant.typ == t
+		}))
+	}
+	for _, vr := range t.variants {
+		variant := vr
+		add(vr.name, &VConstructor{vr})
+		add(vr.name + "?", mkTypePredicate(vr.name + "?", func(r *VRecord) bool {
+			return r.variant == variant
+		}))
+		for i, field := range vr.fields {
+			add(vr.name + "-" + field, vr.accessor(i))
+			add(vr.name + "-with-" + field, vr.updater(i))
+		}
+	}
+	return names, values
+}
+
+// the type called name bound in env by an earlier deftype, if any
+
+func (env *Env) boundType(name string) *RecordType {
+	for n, v := range env.bindings {
+		if c, ok := v.(*VConstructor); ok && env.kinds[n] == DEF_TYPE && c.variant.typ.name == name {
+			return c.variant.typ
+		}
+	}
+	return nil
+}
+
+// forget what an earlier definition of a type bound, so that the
+// constructors and accessors it no longer has go away
+
+func (env *Env) unbindType(t *RecordType) {
+	names, _ := t.bindings()
+	for _, name := range names {
+		if env.kinds[name] == DEF_TYPE {
+			delete(env.bindings, name)
+			delete(env.kinds, name)
+		}
+	}
+}
+
+func mkTypePredicate(name string, pred func(*VRecord) bool) Value {
+	return &VPrimitive{name, mkPrimitive(PrimitiveDesc{name, 1, 1,
+		func(name string, args []Value) (Value, error) {
+			r, ok := args[0].(*VRecord)
+			return &VBoolean{ok && pred(r)}, nil
+		},
+	})}
+}
+
+func (vr *Variant) construct(args []Value) (Value, error) {
+	if len(args) != len(vr.fields) {
+		return nil, newError(ERR_ARITY, &VConstructor{vr}, "Wrong number of arguments to constructor %s", vr.name)
+	}
+	fields := make([]Value, len(args))
+	copy(fields, args)
+	return &VRecord{vr, fields}, nil
+}
+
+func (vr *Variant) check(name string, v Value) (*VRecord, error) {
+	r, ok := v.(*VRecord)
+	if !ok || r.variant != vr {
+		return nil, newError(ERR_TYPE, v, "%s - wrong argument type %s", name, v.typ())
+	}
+	return r, nil
+}
+
+func (vr *Variant) accessor(i int) Value {
+	name := vr.name + "-" + vr.fields[i]
+	return &VPrimitive{name, mkPrimitive(PrimitiveDesc{name, 1, 1,
+		func(name string, args []Value) (Value, error) {
+			r, err := vr.check(name, args[0])
+			if err != nil {
+				return nil, err
+			}
+			return r.fields[i], nil
+		},
+	})}
+}
+
+func (vr *Variant) updater(i int) Value {
+	name := vr.name + "-with-" + vr.fields[i]
+	return &VPrimitive{name, mkPrimitive(PrimitiveDesc{name, 2, 2,
+		func(name string, args []Value) (Value, error) {
+			r, err := vr.check(name, args[0])
+			if err != nil {
+				return nil, err
+			}
+			fields := make([]Value, len(r.fields))
+			copy(fields, r.fields)
+			fields[i] = args[1]
+			return &VRecord{vr, fields}, nil
+		},
+	})}
+}
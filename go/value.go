@@ -2,6 +2,9 @@
 
 import "fmt"
 import "strings"
+import "sort"
+import "math/big"
+import "unicode"
 
 type Value interface {
 	display() string
@@ -32,13 +35,30 @@ type Value interface {
 	isArray() bool
 	getArray() []Value
 	isDict() bool
-	getDict() map[string]Value
+	getDict() map[*VSymbol]Value
 }
 
 type VInteger struct {
 	val int
 }
 
+// integers that don't fit in an int
+// always normalized back to VInteger when they fit
+
+type VBigInt struct {
+	val *big.Int
+}
+
+// exact non-integer ratios
+
+type VRational struct {
+	val *big.Rat
+}
+
+type VFloat struct {
+	val float64
+}
+
 type VBoolean struct {
 	val bool
 }
@@ -57,14 +77,46 @@ type VCons struct {
 	length int
 }
 
+// symbols are interned, so they can be compared with ==
+// always create them with intern()
+
 type VSymbol struct {
 	name string
 }
 
+var symbolTable = map[string]*VSymbol{}
+
+func intern(name string) *VSymbol {
+	if sym, ok := symbolTable[name]; ok {
+		return sym
+	}
+	sym := &VSymbol{name}
+	symbolTable[name] = sym
+	return sym
+}
+
+// keywords are the symbols of the keyword module, written :foo
+// they evaluate to themselves
+
+const keywordModule = "keyword"
+
+func isKeywordName(name string) bool {
+	return len(name) > 1 && name[0] == ':'
+}
+
+func (v *VSymbol) isKeyword() bool {
+	return isKeywordName(v.name)
+}
+
 type VFunction struct {
-	params []string
+	params *Params
 	body AST
 	env *Env
+	name string     // for error traces
+}
+
+type VMacro struct {
+	fn *VFunction   // computes the expansion
 }
 
 type VString struct {
@@ -83,7 +135,42 @@ type VArray struct {
 }
 
 type VDict struct {
-	content map[string]Value
+	content map[*VSymbol]Value
+}
+
+// values of the types defined with deftype
+
+type VRecord struct {
+	variant *Variant
+	fields []Value
+}
+
+// the constructor of a variant of a type defined with deftype
+
+type VConstructor struct {
+	variant *Variant
+}
+
+// instances of classes
+// fields hold reference cells, and methods are bound to the object
+
+type VObject struct {
+	class *Class
+	fields map[string]Value
+	methods map[string]Value
+}
+
+// generic functions, dispatching on the types of their arguments
+
+type VGeneric struct {
+	name string
+	params *Params
+	methods []*Method
+	dflt Value          // nil if there is no default method
+}
+
+type VError struct {
+	err *RuntimeError
 }
   
 func (v *VInteger) display() string {
@@ -107,7 +194,7 @@ func (v *VInteger) boolValue() bool {
 }
 
 func (v *VInteger) apply(args []Value) (Value, error) {
-	return nil, fmt.Errorf("Value %s not applicable", v.str())
+	return nil, newError(ERR_TYPE, v, "Value %s not applicable", v.str())
 }
 
 func (v *VInteger) str() string {
@@ -167,7 +254,7 @@ func (v *VInteger) isNil() bool {
 }
 
 func (v *VInteger) isEqual(vv Value) bool {
-	return vv.isNumber() && v.intValue() == vv.intValue()
+	return vv.isNumber() && numCompare(v, vv) == 0
 }
 
 func (v *VInteger) typ() string {
@@ -194,742 +281,1227 @@ func (v *VInteger) isDict() bool {
 	return false
 }
 
-func (v *VInteger) getDict() map[string]Value {
+func (v *VInteger) getDict() map[*VSymbol]Value {
 	panic(fmt.Sprintf("unchecked access to %s", v.str()))
 }
 
-func (v *VBoolean) display() string {
-	if v.val {
-		return "#t"
-	} else {
-		return "#f"
-	}
+func (v *VBigInt) display() string {
+	return v.val.String()
 }
 
-func (v *VBoolean) displayCDR() string {
+func (v *VBigInt) displayCDR() string {
 	panic(fmt.Sprintf("unchecked access to %s", v.str()))
 }
 
-func (v *VBoolean) intValue() int {
+func (v *VBigInt) intValue() int {
 	panic(fmt.Sprintf("unchecked access to %s", v.str()))
 }
 
-func (v *VBoolean) strValue() string {
+func (v *VBigInt) strValue() string {
 	panic(fmt.Sprintf("unchecked access to %s", v.str()))
 }
 
-func (v *VBoolean) boolValue() bool {
-	return v.val
+func (v *VBigInt) boolValue() bool {
+	panic(fmt.Sprintf("unchecked access to %s", v.str()))
 }
 
-func (v *VBoolean) apply(args []Value) (Value, error) {
-	return nil, fmt.Errorf("Value %s not applicable", v.str())
+func (v *VBigInt) apply(args []Value) (Value, error) {
+	return nil, newError(ERR_TYPE, v, "Value %s not applicable", v.str())
 }
 
-func (v *VBoolean) str() string {
-	if v.val {
-		return "VBoolean[true]"
-	} else {
-		return "VBoolean[false]"
-	}
+func (v *VBigInt) str() string {
+	return fmt.Sprintf("VBigInt[%s]", v.display())
 }
 
-func (v *VBoolean) headValue() Value {
+func (v *VBigInt) headValue() Value {
 	panic(fmt.Sprintf("unchecked access to %s", v.str()))
 }
 
-func (v *VBoolean) tailValue() Value {
+func (v *VBigInt) tailValue() Value {
 	panic(fmt.Sprintf("unchecked access to %s", v.str()))
 }
 
-func (v *VBoolean) isAtom() bool {
+func (v *VBigInt) isAtom() bool {
 	return true
 }
 
-func (v *VBoolean) isSymbol() bool {
+func (v *VBigInt) isSymbol() bool {
 	return false
 }
 
-func (v *VBoolean) isCons() bool {
+func (v *VBigInt) isCons() bool {
 	return false
 }
 
-func (v *VBoolean) isEmpty() bool {
+func (v *VBigInt) isEmpty() bool {
 	return false
 }
 
-func (v *VBoolean) isNumber() bool {
-	return false
+func (v *VBigInt) isNumber() bool {
+	return true
 }
 
-func (v *VBoolean) isBool() bool {
-	return true
+func (v *VBigInt) isBool() bool {
+	return false
 }
 
-func (v *VBoolean) isRef() bool {
+func (v *VBigInt) isRef() bool {
 	return false
 }
 
-func (v *VBoolean) isString() bool {
+func (v *VBigInt) isString() bool {
 	return false
 }
 
-func (v *VBoolean) isFunction() bool {
+func (v *VBigInt) isFunction() bool {
 	return false
 }
 
-func (v *VBoolean) isTrue() bool {
-	return v.val
+func (v *VBigInt) isTrue() bool {
+	return v.val.Sign() != 0
 }
 
-func (v *VBoolean) isNil() bool {
+func (v *VBigInt) isNil() bool {
 	return false
 }
 
-func (v *VBoolean) isEqual(vv Value) bool {
-	return vv.isBool() && v.boolValue() == vv.boolValue()
+func (v *VBigInt) isEqual(vv Value) bool {
+	return vv.isNumber() && numCompare(v, vv) == 0
 }
 
-func (v *VBoolean) typ() string {
-	return "bool"
+func (v *VBigInt) typ() string {
+	return "int"
 }
 
-func (v *VBoolean) getValue() Value {
+func (v *VBigInt) getValue() Value {
 	panic(fmt.Sprintf("unchecked access to %s", v.str()))
 }
 
-func (v *VBoolean) setValue(cv Value) {
+func (v *VBigInt) setValue(cv Value) {
 	panic(fmt.Sprintf("unchecked access to %s", v.str()))
 }
 
-func (v *VBoolean) isArray() bool {
+func (v *VBigInt) isArray() bool {
 	return false
 }
 
-func (v *VBoolean) getArray() []Value {
+func (v *VBigInt) getArray() []Value {
 	panic(fmt.Sprintf("unchecked access to %s", v.str()))
 }
 
-func (v *VBoolean) isDict() bool {
+func (v *VBigInt) isDict() bool {
 	return false
 }
 
-func (v *VBoolean) getDict() map[string]Value {
+func (v *VBigInt) getDict() map[*VSymbol]Value {
 	panic(fmt.Sprintf("unchecked access to %s", v.str()))
 }
 
-func (v *VPrimitive) display() string {
-	return fmt.Sprintf("#<prim %s>", v.name)
+func (v *VRational) display() string {
+	return v.val.RatString()
 }
 
-func (v *VPrimitive) displayCDR() string {
+func (v *VRational) displayCDR() string {
 	panic(fmt.Sprintf("unchecked access to %s", v.str()))
 }
 
-func (v *VPrimitive) intValue() int {
+func (v *VRational) intValue() int {
 	panic(fmt.Sprintf("unchecked access to %s", v.str()))
 }
 
-func (v *VPrimitive) strValue() string {
+func (v *VRational) strValue() string {
 	panic(fmt.Sprintf("unchecked access to %s", v.str()))
 }
 
-func (v *VPrimitive) boolValue() bool {
+func (v *VRational) boolValue() bool {
 	panic(fmt.Sprintf("unchecked access to %s", v.str()))
 }
 
-func (v *VPrimitive) apply(args []Value) (Value, error) {
-	return v.primitive(args)
+func (v *VRational) apply(args []Value) (Value, error) {
+	return nil, newError(ERR_TYPE, v, "Value %s not applicable", v.str())
 }
 
-func (v *VPrimitive) str() string {
-	return fmt.Sprintf("VPrimitive[%s]", v.name)
+func (v *VRational) str() string {
+	return fmt.Sprintf("VRational[%s]", v.display())
 }
 
-func (v *VPrimitive) headValue() Value {
+func (v *VRational) headValue() Value {
 	panic(fmt.Sprintf("unchecked access to %s", v.str()))
 }
 
-func (v *VPrimitive) tailValue() Value {
+func (v *VRational) tailValue() Value {
 	panic(fmt.Sprintf("unchecked access to %s", v.str()))
 }
 
-func (v *VPrimitive) isAtom() bool {
-	return false
+func (v *VRational) isAtom() bool {
+	return true
 }
 
-func (v *VPrimitive) isSymbol() bool {
+func (v *VRational) isSymbol() bool {
 	return false
 }
 
-func (v *VPrimitive) isCons() bool {
+func (v *VRational) isCons() bool {
 	return false
 }
 
-func (v *VPrimitive) isEmpty() bool {
+func (v *VRational) isEmpty() bool {
 	return false
 }
 
-func (v *VPrimitive) isNumber() bool {
-	return false
+func (v *VRational) isNumber() bool {
+	return true
 }
 
-func (v *VPrimitive) isBool() bool {
+func (v *VRational) isBool() bool {
 	return false
 }
 
-func (v *VPrimitive) isRef() bool {
+func (v *VRational) isRef() bool {
 	return false
 }
 
-func (v *VPrimitive) isString() bool {
+func (v *VRational) isString() bool {
 	return false
 }
 
-func (v *VPrimitive) isFunction() bool {
-	return true
+func (v *VRational) isFunction() bool {
+	return false
 }
 
-func (v *VPrimitive) isTrue() bool {
-	return true
+func (v *VRational) isTrue() bool {
+	return v.val.Sign() != 0
 }
 
-func (v *VPrimitive) isNil() bool {
+func (v *VRational) isNil() bool {
 	return false
 }
 
-func (v *VPrimitive) isEqual(vv Value) bool {
-	return v == vv      // pointer equality
+func (v *VRational) isEqual(vv Value) bool {
+	return vv.isNumber() && numCompare(v, vv) == 0
 }
 
-func (v *VPrimitive) typ() string {
-	return "fun"
+func (v *VRational) typ() string {
+	return "rational"
 }
 
-func (v *VPrimitive) getValue() Value {
+func (v *VRational) getValue() Value {
 	panic(fmt.Sprintf("unchecked access to %s", v.str()))
 }
 
-func (v *VPrimitive) setValue(cv Value) {
+func (v *VRational) setValue(cv Value) {
 	panic(fmt.Sprintf("unchecked access to %s", v.str()))
 }
 
-func (v *VPrimitive) isArray() bool {
+func (v *VRational) isArray() bool {
 	return false
 }
 
-func (v *VPrimitive) getArray() []Value {
+func (v *VRational) getArray() []Value {
 	panic(fmt.Sprintf("unchecked access to %s", v.str()))
 }
 
-func (v *VPrimitive) isDict() bool {
+func (v *VRational) isDict() bool {
 	return false
 }
 
-func (v *VPrimitive) getDict() map[string]Value {
+func (v *VRational) getDict() map[*VSymbol]Value {
 	panic(fmt.Sprintf("unchecked access to %s", v.str()))
 }
 
-func (v *VEmpty) display() string {
-	return "()"
+func (v *VFloat) display() string {
+	return displayFloat(v.val)
 }
 
-func (v *VEmpty) displayCDR() string {
-	return ")"
+func (v *VFloat) displayCDR() string {
+	panic(fmt.Sprintf("unchecked access to %s", v.str()))
 }
 
-func (v *VEmpty) intValue() int {
+func (v *VFloat) intValue() int {
 	panic(fmt.Sprintf("unchecked access to %s", v.str()))
 }
 
-func (v *VEmpty) strValue() string {
+func (v *VFloat) strValue() string {
 	panic(fmt.Sprintf("unchecked access to %s", v.str()))
 }
 
-func (v *VEmpty) boolValue() bool {
+func (v *VFloat) boolValue() bool {
 	panic(fmt.Sprintf("unchecked access to %s", v.str()))
 }
 
-func (v *VEmpty) apply(args []Value) (Value, error) {
-	return nil, fmt.Errorf("Value %s not applicable", v.str())
+func (v *VFloat) apply(args []Value) (Value, error) {
+	return nil, newError(ERR_TYPE, v, "Value %s not applicable", v.str())
 }
 
-func (v *VEmpty) str() string {
-	return fmt.Sprintf("VEmpty")
+func (v *VFloat) str() string {
+	return fmt.Sprintf("VFloat[%s]", v.display())
 }
 
-func (v *VEmpty) headValue() Value {
+func (v *VFloat) headValue() Value {
 	panic(fmt.Sprintf("unchecked access to %s", v.str()))
 }
 
-func (v *VEmpty) tailValue() Value {
+func (v *VFloat) tailValue() Value {
 	panic(fmt.Sprintf("unchecked access to %s", v.str()))
 }
 
-func (v *VEmpty) isAtom() bool {
-	return false
+func (v *VFloat) isAtom() bool {
+	return true
 }
 
-func (v *VEmpty) isSymbol() bool {
+func (v *VFloat) isSymbol() bool {
 	return false
 }
 
-func (v *VEmpty) isCons() bool {
+func (v *VFloat) isCons() bool {
 	return false
 }
 
-func (v *VEmpty) isEmpty() bool {
-	return true
+func (v *VFloat) isEmpty() bool {
+	return false
 }
 
-func (v *VEmpty) isNumber() bool {
-	return false
+func (v *VFloat) isNumber() bool {
+	return true
 }
 
-func (v *VEmpty) isBool() bool {
+func (v *VFloat) isBool() bool {
 	return false
 }
 
-func (v *VEmpty) isRef() bool {
+func (v *VFloat) isRef() bool {
 	return false
 }
 
-func (v *VEmpty) isString() bool {
+func (v *VFloat) isString() bool {
 	return false
 }
 
-func (v *VEmpty) isFunction() bool {
+func (v *VFloat) isFunction() bool {
 	return false
 }
 
-func (v *VEmpty) isTrue() bool {
-	return false
+func (v *VFloat) isTrue() bool {
+	return v.val != 0
 }
 
-func (v *VEmpty) isNil() bool {
+func (v *VFloat) isNil() bool {
 	return false
 }
 
-func (v *VEmpty) isEqual(vv Value) bool {
-	return vv.isEmpty()
+func (v *VFloat) isEqual(vv Value) bool {
+	return vv.isNumber() && numCompare(v, vv) == 0
 }
 
-func (v *VEmpty) typ() string {
-	return "list"
+func (v *VFloat) typ() string {
+	return "float"
 }
 
-func (v *VEmpty) getValue() Value {
+func (v *VFloat) getValue() Value {
 	panic(fmt.Sprintf("unchecked access to %s", v.str()))
 }
 
-func (v *VEmpty) setValue(cv Value) {
+func (v *VFloat) setValue(cv Value) {
 	panic(fmt.Sprintf("unchecked access to %s", v.str()))
 }
 
-func (v *VEmpty) isArray() bool {
+func (v *VFloat) isArray() bool {
 	return false
 }
 
-func (v *VEmpty) getArray() []Value {
+func (v *VFloat) getArray() []Value {
 	panic(fmt.Sprintf("unchecked access to %s", v.str()))
 }
 
-func (v *VEmpty) isDict() bool {
+func (v *VFloat) isDict() bool {
 	return false
 }
 
-func (v *VEmpty) getDict() map[string]Value {
+func (v *VFloat) getDict() map[*VSymbol]Value {
 	panic(fmt.Sprintf("unchecked access to %s", v.str()))
 }
 
-func (v *VCons) display() string {
-	return "(" + v.head.display() + v.tail.displayCDR()
+func (v *VBoolean) display() string {
+	if v.val {
+		return "#t"
+	} else {
+		return "#f"
+	}
 }
 
-func (v *VCons) displayCDR() string {
-	return " " + v.head.display() + v.tail.displayCDR()
+func (v *VBoolean) displayCDR() string {
+	panic(fmt.Sprintf("unchecked access to %s", v.str()))
 }
 
-func (v *VCons) intValue() int {
+func (v *VBoolean) intValue() int {
 	panic(fmt.Sprintf("unchecked access to %s", v.str()))
 }
 
-func (v *VCons) strValue() string {
+func (v *VBoolean) strValue() string {
 	panic(fmt.Sprintf("unchecked access to %s", v.str()))
 }
 
-func (v *VCons) boolValue() bool {
-	panic(fmt.Sprintf("unchecked access to %s", v.str()))
+func (v *VBoolean) boolValue() bool {
+	return v.val
 }
 
-func (v *VCons) apply(args []Value) (Value, error) {
-	return nil, fmt.Errorf("Value %s not applicable", v.str())
+func (v *VBoolean) apply(args []Value) (Value, error) {
+	return nil, newError(ERR_TYPE, v, "Value %s not applicable", v.str())
 }
 
-func (v *VCons) str() string {
-	return fmt.Sprintf("VCons[%s %s]", v.head.str(), v.tail.str())
+func (v *VBoolean) str() string {
+	if v.val {
+		return "VBoolean[true]"
+	} else {
+		return "VBoolean[false]"
+	}
 }
 
-func (v *VCons) headValue() Value {
-	return v.head
+func (v *VBoolean) headValue() Value {
+	panic(fmt.Sprintf("unchecked access to %s", v.str()))
 }
 
-func (v *VCons) tailValue() Value {
-	return v.tail
+func (v *VBoolean) tailValue() Value {
+	panic(fmt.Sprintf("unchecked access to %s", v.str()))
 }
 
-func (v *VCons) isAtom() bool {
-	return false
+func (v *VBoolean) isAtom() bool {
+	return true
 }
 
-func (v *VCons) isSymbol() bool {
+func (v *VBoolean) isSymbol() bool {
 	return false
 }
 
-func (v *VCons) isCons() bool {
-	return true
+func (v *VBoolean) isCons() bool {
+	return false
 }
 
-func (v *VCons) isEmpty() bool {
+func (v *VBoolean) isEmpty() bool {
 	return false
 }
 
-func (v *VCons) isNumber() bool {
+func (v *VBoolean) isNumber() bool {
 	return false
 }
 
-func (v *VCons) isBool() bool {
-	return false
+func (v *VBoolean) isBool() bool {
+	return true
 }
 
-func (v *VCons) isRef() bool {
+func (v *VBoolean) isRef() bool {
 	return false
 }
 
-func (v *VCons) isString() bool {
+func (v *VBoolean) isString() bool {
 	return false
 }
 
-func (v *VCons) isFunction() bool {
+func (v *VBoolean) isFunction() bool {
 	return false
 }
 
-func (v *VCons) isTrue() bool {
-	return true
+func (v *VBoolean) isTrue() bool {
+	return v.val
 }
 
-func (v *VCons) isNil() bool {
+func (v *VBoolean) isNil() bool {
 	return false
 }
 
-func (v *VCons) isEqual(vv Value) bool {
-	if !vv.isCons() {
-		return false
-	}
-	var curr1 Value = v
-	var curr2 Value = vv
-	for curr1.isCons() {
-		if !curr2.isCons() {
-			return false
-		}
-		if !curr1.headValue().isEqual(curr2.headValue()) {
-			return false
-		}
-		curr1 = curr1.tailValue()
-		curr2 = curr2.tailValue()
-	}
-	return curr1.isEqual(curr2)   // should both be empty at the end
+func (v *VBoolean) isEqual(vv Value) bool {
+	return vv.isBool() && v.boolValue() == vv.boolValue()
 }
 
-func (v *VCons) typ() string {
-	return "list"
+func (v *VBoolean) typ() string {
+	return "bool"
 }
 
-func (v *VCons) getValue() Value {
+func (v *VBoolean) getValue() Value {
 	panic(fmt.Sprintf("unchecked access to %s", v.str()))
 }
 
-func (v *VCons) setValue(cv Value) {
+func (v *VBoolean) setValue(cv Value) {
 	panic(fmt.Sprintf("unchecked access to %s", v.str()))
 }
 
-func (v *VCons) isArray() bool {
+func (v *VBoolean) isArray() bool {
 	return false
 }
 
-func (v *VCons) getArray() []Value {
+func (v *VBoolean) getArray() []Value {
 	panic(fmt.Sprintf("unchecked access to %s", v.str()))
 }
 
-func (v *VCons) isDict() bool {
+func (v *VBoolean) isDict() bool {
 	return false
 }
 
-func (v *VCons) getDict() map[string]Value {
+func (v *VBoolean) getDict() map[*VSymbol]Value {
 	panic(fmt.Sprintf("unchecked access to %s", v.str()))
 }
 
-func (v *VSymbol) display() string {
-	return v.name
+func (v *VPrimitive) display() string {
+	return fmt.Sprintf("#primitive(%s)", escapeString(v.name))
 }
 
-func (v *VSymbol) displayCDR() string {
+func (v *VPrimitive) displayCDR() string {
 	panic(fmt.Sprintf("unchecked access to %s", v.str()))
 }
 
-func (v *VSymbol) intValue() int {
+func (v *VPrimitive) intValue() int {
 	panic(fmt.Sprintf("unchecked access to %s", v.str()))
 }
 
-func (v *VSymbol) strValue() string {
-	return v.name
+func (v *VPrimitive) strValue() string {
+	panic(fmt.Sprintf("unchecked access to %s", v.str()))
 }
 
-func (v *VSymbol) boolValue() bool {
+func (v *VPrimitive) boolValue() bool {
 	panic(fmt.Sprintf("unchecked access to %s", v.str()))
 }
 
-func (v *VSymbol) apply(args []Value) (Value, error) {
-	return nil, fmt.Errorf("Value %s not applicable", v.str())
+func (v *VPrimitive) apply(args []Value) (Value, error) {
+	return v.primitive(args)
 }
 
-func (v *VSymbol) str() string {
-	return fmt.Sprintf("VSymbol[%s]", v.name)
+func (v *VPrimitive) str() string {
+	return fmt.Sprintf("VPrimitive[%s]", v.name)
 }
 
-func (v *VSymbol) headValue() Value {
+func (v *VPrimitive) headValue() Value {
 	panic(fmt.Sprintf("unchecked access to %s", v.str()))
 }
 
-func (v *VSymbol) tailValue() Value {
+func (v *VPrimitive) tailValue() Value {
 	panic(fmt.Sprintf("unchecked access to %s", v.str()))
 }
 
-func (v *VSymbol) isAtom() bool {
-	return true
+func (v *VPrimitive) isAtom() bool {
+	return false
 }
 
-func (v *VSymbol) isSymbol() bool {
-	return true
+func (v *VPrimitive) isSymbol() bool {
+	return false
 }
 
-func (v *VSymbol) isCons() bool {
+func (v *VPrimitive) isCons() bool {
 	return false
 }
 
-func (v *VSymbol) isEmpty() bool {
+func (v *VPrimitive) isEmpty() bool {
 	return false
 }
 
-func (v *VSymbol) isNumber() bool {
+func (v *VPrimitive) isNumber() bool {
 	return false
 }
 
-func (v *VSymbol) isBool() bool {
+func (v *VPrimitive) isBool() bool {
 	return false
 }
 
-func (v *VSymbol) isRef() bool {
+func (v *VPrimitive) isRef() bool {
 	return false
 }
 
-func (v *VSymbol) isString() bool {
+func (v *VPrimitive) isString() bool {
 	return false
 }
 
-func (v *VSymbol) isFunction() bool {
-	return false
+func (v *VPrimitive) isFunction() bool {
+	return true
 }
 
-func (v *VSymbol) isTrue() bool {
+func (v *VPrimitive) isTrue() bool {
 	return true
 }
 
-func (v *VSymbol) isNil() bool {
+func (v *VPrimitive) isNil() bool {
 	return false
 }
 
-func (v *VSymbol) isEqual(vv Value) bool {
-	return vv.isSymbol() && v.strValue() == vv.strValue()
+func (v *VPrimitive) isEqual(vv Value) bool {
+	return v == vv      // pointer equality
 }
 
-func (v *VSymbol) typ() string {
-	return "symbol"
+func (v *VPrimitive) typ() string {
+	return "fun"
 }
 
-func (v *VSymbol) getValue() Value {
+func (v *VPrimitive) getValue() Value {
 	panic(fmt.Sprintf("unchecked access to %s", v.str()))
 }
 
-func (v *VSymbol) setValue(cv Value) {
+func (v *VPrimitive) setValue(cv Value) {
 	panic(fmt.Sprintf("unchecked access to %s", v.str()))
 }
 
-func (v *VSymbol) isArray() bool {
+func (v *VPrimitive) isArray() bool {
 	return false
 }
 
-func (v *VSymbol) getArray() []Value {
+func (v *VPrimitive) getArray() []Value {
 	panic(fmt.Sprintf("unchecked access to %s", v.str()))
 }
 
-func (v *VSymbol) isDict() bool {
+func (v *VPrimitive) isDict() bool {
 	return false
 }
 
-func (v *VSymbol) getDict() map[string]Value {
+func (v *VPrimitive) getDict() map[*VSymbol]Value {
 	panic(fmt.Sprintf("unchecked access to %s", v.str()))
 }
 
-func (v *VFunction) display() string {
-	return fmt.Sprintf("#<fun %s ...>", strings.Join(v.params, " "))
+func (v *VEmpty) display() string {
+	return "()"
 }
 
-func (v *VFunction) displayCDR() string {
-	panic(fmt.Sprintf("unchecked access to %s", v.str()))
+func (v *VEmpty) displayCDR() string {
+	return ")"
 }
 
-func (v *VFunction) intValue() int {
+func (v *VEmpty) intValue() int {
 	panic(fmt.Sprintf("unchecked access to %s", v.str()))
 }
 
-func (v *VFunction) strValue() string {
+func (v *VEmpty) strValue() string {
 	panic(fmt.Sprintf("unchecked access to %s", v.str()))
 }
 
-func (v *VFunction) boolValue() bool {
+func (v *VEmpty) boolValue() bool {
 	panic(fmt.Sprintf("unchecked access to %s", v.str()))
 }
 
-func (v *VFunction) apply(args []Value) (Value, error) {
-	if len(v.params) != len(args) {
-		return nil, fmt.Errorf("Wrong number of arguments to application to %s", v.str())
-	}
-	newEnv := v.env.layer(v.params, args)
-	return v.body.eval(newEnv)
+func (v *VEmpty) apply(args []Value) (Value, error) {
+	return nil, newError(ERR_TYPE, v, "Value %s not applicable", v.str())
 }
 
-func (v *VFunction) str() string {
-	return fmt.Sprintf("VFunction[[%s] %s]", strings.Join(v.params, " "), v.body.str())
+func (v *VEmpty) str() string {
+	return fmt.Sprintf("VEmpty")
 }
 
-func (v *VFunction) headValue() Value {
+func (v *VEmpty) headValue() Value {
 	panic(fmt.Sprintf("unchecked access to %s", v.str()))
 }
 
-func (v *VFunction) tailValue() Value {
+func (v *VEmpty) tailValue() Value {
 	panic(fmt.Sprintf("unchecked access to %s", v.str()))
 }
 
-func (v *VFunction) isAtom() bool {
+func (v *VEmpty) isAtom() bool {
 	return false
 }
 
-func (v *VFunction) isSymbol() bool {
+func (v *VEmpty) isSymbol() bool {
 	return false
 }
 
-func (v *VFunction) isCons() bool {
+func (v *VEmpty) isCons() bool {
 	return false
 }
 
-func (v *VFunction) isEmpty() bool {
-	return false
+func (v *VEmpty) isEmpty() bool {
+	return true
 }
 
-func (v *VFunction) isNumber() bool {
+func (v *VEmpty) isNumber() bool {
 	return false
 }
 
-func (v *VFunction) isBool() bool {
+func (v *VEmpty) isBool() bool {
 	return false
 }
 
-func (v *VFunction) isRef() bool {
+func (v *VEmpty) isRef() bool {
 	return false
 }
 
-func (v *VFunction) isString() bool {
+func (v *VEmpty) isString() bool {
 	return false
 }
 
-func (v *VFunction) isFunction() bool {
-	return true
+func (v *VEmpty) isFunction() bool {
+	return false
 }
 
-func (v *VFunction) isTrue() bool {
-	return true
+func (v *VEmpty) isTrue() bool {
+	return false
 }
 
-func (v *VFunction) isNil() bool {
+func (v *VEmpty) isNil() bool {
 	return false
 }
 
-func (v *VFunction) isEqual(vv Value) bool {
-	return v == vv    // pointer equality
+func (v *VEmpty) isEqual(vv Value) bool {
+	return vv.isEmpty()
 }
 
-func (v *VFunction) typ() string {
-	return "fun"
+func (v *VEmpty) typ() string {
+	return "list"
 }
 
-func (v *VFunction) getValue() Value {
+func (v *VEmpty) getValue() Value {
 	panic(fmt.Sprintf("unchecked access to %s", v.str()))
 }
 
-func (v *VFunction) setValue(cv Value) {
+func (v *VEmpty) setValue(cv Value) {
 	panic(fmt.Sprintf("unchecked access to %s", v.str()))
 }
 
-func (v *VFunction) isArray() bool {
+func (v *VEmpty) isArray() bool {
 	return false
 }
 
-func (v *VFunction) getArray() []Value {
+func (v *VEmpty) getArray() []Value {
 	panic(fmt.Sprintf("unchecked access to %s", v.str()))
 }
 
-func (v *VFunction) isDict() bool {
+func (v *VEmpty) isDict() bool {
 	return false
 }
 
-func (v *VFunction) getDict() map[string]Value {
+func (v *VEmpty) getDict() map[*VSymbol]Value {
 	panic(fmt.Sprintf("unchecked access to %s", v.str()))
 }
 
-func (v *VString) display() string {
-	return "\"" + v.val + "\""
+func (v *VCons) display() string {
+	return "(" + v.head.display() + displayTail(v.tail)
 }
 
-func (v *VString) displayCDR() string {
+func (v *VCons) displayCDR() string {
+	return " " + v.head.display() + displayTail(v.tail)
+}
+
+func displayTail(tail Value) string {
+	if tail.isCons() || tail.isEmpty() {
+		return tail.displayCDR()
+	}
+	return " . " + tail.display() + ")"
+}
+
+func (v *VCons) intValue() int {
 	panic(fmt.Sprintf("unchecked access to %s", v.str()))
 }
 
-func (v *VString) intValue() int {
+func (v *VCons) strValue() string {
 	panic(fmt.Sprintf("unchecked access to %s", v.str()))
 }
 
-func (v *VString) strValue() string {
-	return v.val
+func (v *VCons) boolValue() bool {
+	panic(fmt.Sprintf("unchecked access to %s", v.str()))
 }
 
-func (v *VString) boolValue() bool {
+func (v *VCons) apply(args []Value) (Value, error) {
+	return nil, newError(ERR_TYPE, v, "Value %s not applicable", v.str())
+}
+
+func (v *VCons) str() string {
+	return fmt.Sprintf("VCons[%s %s]", v.head.str(), v.tail.str())
+}
+
+func (v *VCons) headValue() Value {
+	return v.head
+}
+
+func (v *VCons) tailValue() Value {
+	return v.tail
+}
+
+func (v *VCons) isAtom() bool {
+	return false
+}
+
+func (v *VCons) isSymbol() bool {
+	return false
+}
+
+func (v *VCons) isCons() bool {
+	return true
+}
+
+func (v *VCons) isEmpty() bool {
+	return false
+}
+
+func (v *VCons) isNumber() bool {
+	return false
+}
+
+func (v *VCons) isBool() bool {
+	return false
+}
+
+func (v *VCons) isRef() bool {
+	return false
+}
+
+func (v *VCons) isString() bool {
+	return false
+}
+
+func (v *VCons) isFunction() bool {
+	return false
+}
+
+func (v *VCons) isTrue() bool {
+	return true
+}
+
+func (v *VCons) isNil() bool {
+	return false
+}
+
+func (v *VCons) isEqual(vv Value) bool {
+	if !vv.isCons() {
+		return false
+	}
+	var curr1 Value = v
+	var curr2 Value = vv
+	for curr1.isCons() {
+		if !curr2.isCons() {
+			return false
+		}
+		if !curr1.headValue().isEqual(curr2.headValue()) {
+			return false
+		}
+		curr1 = curr1.tailValue()
+		curr2 = curr2.tailValue()
+	}
+	return curr1.isEqual(curr2)   // should both be empty at the end
+}
+
+func (v *VCons) typ() string {
+	return "list"
+}
+
+func (v *VCons) getValue() Value {
 	panic(fmt.Sprintf("unchecked access to %s", v.str()))
 }
 
-func (v *VString) apply(args []Value) (Value, error) {
-	return nil, fmt.Errorf("Value %s not applicable", v.str())
+func (v *VCons) setValue(cv Value) {
+	panic(fmt.Sprintf("unchecked access to %s", v.str()))
 }
 
-func (v *VString) str() string {
-	return fmt.Sprintf("VString[%s]", v.str)
+func (v *VCons) isArray() bool {
+	return false
 }
 
-func (v *VString) headValue() Value {
+func (v *VCons) getArray() []Value {
 	panic(fmt.Sprintf("unchecked access to %s", v.str()))
 }
 
-func (v *VString) tailValue() Value {
+func (v *VCons) isDict() bool {
+	return false
+}
+
+func (v *VCons) getDict() map[*VSymbol]Value {
+	panic(fmt.Sprintf("unchecked access to %s", v.str()))
+}
+
+func (v *VSymbol) display() string {
+	return v.name
+}
+
+func (v *VSymbol) displayCDR() string {
+	panic(fmt.Sprintf("unchecked access to %s", v.str()))
+}
+
+func (v *VSymbol) intValue() int {
+	panic(fmt.Sprintf("unchecked access to %s", v.str()))
+}
+
+func (v *VSymbol) strValue() string {
+	return v.name
+}
+
+func (v *VSymbol) boolValue() bool {
+	panic(fmt.Sprintf("unchecked access to %s", v.str()))
+}
+
+func (v *VSymbol) apply(args []Value) (Value, error) {
+	return nil, newError(ERR_TYPE, v, "Value %s not applicable", v.str())
+}
+
+func (v *VSymbol) str() string {
+	return fmt.Sprintf("VSymbol[%s]", v.name)
+}
+
+func (v *VSymbol) headValue() Value {
+	panic(fmt.Sprintf("unchecked access to %s", v.str()))
+}
+
+func (v *VSymbol) tailValue() Value {
+	panic(fmt.Sprintf("unchecked access to %s", v.str()))
+}
+
+func (v *VSymbol) isAtom() bool {
+	return true
+}
+
+func (v *VSymbol) isSymbol() bool {
+	return true
+}
+
+func (v *VSymbol) isCons() bool {
+	return false
+}
+
+func (v *VSymbol) isEmpty() bool {
+	return false
+}
+
+func (v *VSymbol) isNumber() bool {
+	return false
+}
+
+func (v *VSymbol) isBool() bool {
+	return false
+}
+
+func (v *VSymbol) isRef() bool {
+	return false
+}
+
+func (v *VSymbol) isString() bool {
+	return false
+}
+
+func (v *VSymbol) isFunction() bool {
+	return false
+}
+
+func (v *VSymbol) isTrue() bool {
+	return true
+}
+
+func (v *VSymbol) isNil() bool {
+	return false
+}
+
+func (v *VSymbol) isEqual(vv Value) bool {
+	return v == vv
+}
+
+func (v *VSymbol) typ() string {
+	return "symbol"
+}
+
+func (v *VSymbol) getValue() Value {
+	panic(fmt.Sprintf("unchecked access to %s", v.str()))
+}
+
+func (v *VSymbol) setValue(cv Value) {
+	panic(fmt.Sprintf("unchecked access to %s", v.str()))
+}
+
+func (v *VSymbol) isArray() bool {
+	return false
+}
+
+func (v *VSymbol) getArray() []Value {
+	panic(fmt.Sprintf("unchecked access to %s", v.str()))
+}
+
+func (v *VSymbol) isDict() bool {
+	return false
+}
+
+func (v *VSymbol) getDict() map[*VSymbol]Value {
+	panic(fmt.Sprintf("unchecked access to %s", v.str()))
+}
+
+func (v *VFunction) display() string {
+	return fmt.Sprintf("#<fun %s ...>", v.params.str())
+}
+
+func (v *VFunction) displayCDR() string {
+	panic(fmt.Sprintf("unchecked access to %s", v.str()))
+}
+
+func (v *VFunction) intValue() int {
+	panic(fmt.Sprintf("unchecked access to %s", v.str()))
+}
+
+func (v *VFunction) strValue() string {
+	panic(fmt.Sprintf("unchecked access to %s", v.str()))
+}
+
+func (v *VFunction) boolValue() bool {
+	panic(fmt.Sprintf("unchecked access to %s", v.str()))
+}
+
+func (v *VFunction) apply(args []Value) (Value, error) {
+	newEnv, err := v.params.bind(v, args)
+	if err != nil {
+		return nil, err
+	}
+	result, err := v.body.eval(newEnv)
+	if err != nil {
+		return nil, traceError(err, &Frame{v, nil})
+	}
+	return result, nil
+}
+
+func (v *VFunction) str() string {
+	return fmt.Sprintf("VFunction[[%s] %s]", v.params.str(), v.body.str())
+}
+
+func (v *VFunction) headValue() Value {
+	panic(fmt.Sprintf("unchecked access to %s", v.str()))
+}
+
+func (v *VFunction) tailValue() Value {
+	panic(fmt.Sprintf("unchecked access to %s", v.str()))
+}
+
+func (v *VFunction) isAtom() bool {
+	return false
+}
+
+func (v *VFunction) isSymbol() bool {
+	return false
+}
+
+func (v *VFunction) isCons() bool {
+	return false
+}
+
+func (v *VFunction) isEmpty() bool {
+	return false
+}
+
+func (v *VFunction) isNumber() bool {
+	return false
+}
+
+func (v *VFunction) isBool() bool {
+	return false
+}
+
+func (v *VFunction) isRef() bool {
+	return false
+}
+
+func (v *VFunction) isString() bool {
+	return false
+}
+
+func (v *VFunction) isFunction() bool {
+	return true
+}
+
+func (v *VFunction) isTrue() bool {
+	return true
+}
+
+func (v *VFunction) isNil() bool {
+	return false
+}
+
+func (v *VFunction) isEqual(vv Value) bool {
+	return v == vv    // pointer equality
+}
+
+func (v *VFunction) typ() string {
+	return "fun"
+}
+
+func (v *VFunction) getValue() Value {
+	panic(fmt.Sprintf("unchecked access to %s", v.str()))
+}
+
+func (v *VFunction) setValue(cv Value) {
+	panic(fmt.Sprintf("unchecked access to %s", v.str()))
+}
+
+func (v *VFunction) isArray() bool {
+	return false
+}
+
+func (v *VFunction) getArray() []Value {
+	panic(fmt.Sprintf("unchecked access to %s", v.str()))
+}
+
+func (v *VFunction) isDict() bool {
+	return false
+}
+
+func (v *VFunction) getDict() map[*VSymbol]Value {
+	panic(fmt.Sprintf("unchecked access to %s", v.str()))
+}
+
+func (v *VMacro) display() string {
+	return fmt.Sprintf("#<macro %s ...>", v.fn.params.str())
+}
+
+func (v *VMacro) displayCDR() string {
+	panic(fmt.Sprintf("unchecked access to %s", v.str()))
+}
+
+func (v *VMacro) intValue() int {
+	panic(fmt.Sprintf("unchecked access to %s", v.str()))
+}
+
+func (v *VMacro) strValue() string {
+	panic(fmt.Sprintf("unchecked access to %s", v.str()))
+}
+
+func (v *VMacro) boolValue() bool {
+	panic(fmt.Sprintf("unchecked access to %s", v.str()))
+}
+
+func (v *VMacro) apply(args []Value) (Value, error) {
+	return nil, newError(ERR_TYPE, v, "Macro %s not applicable", v.fn.name)
+}
+
+func (v *VMacro) str() string {
+	return fmt.Sprintf("VMacro[[%s] %s]", v.fn.params.str(), v.fn.body.str())
+}
+
+func (v *VMacro) headValue() Value {
+	panic(fmt.Sprintf("unchecked access to %s", v.str()))
+}
+
+func (v *VMacro) tailValue() Value {
+	panic(fmt.Sprintf("unchecked access to %s", v.str()))
+}
+
+func (v *VMacro) isAtom() bool {
+	return false
+}
+
+func (v *VMacro) isSymbol() bool {
+	return false
+}
+
+func (v *VMacro) isCons() bool {
+	return false
+}
+
+func (v *VMacro) isEmpty() bool {
+	return false
+}
+
+func (v *VMacro) isNumber() bool {
+	return false
+}
+
+func (v *VMacro) isBool() bool {
+	return false
+}
+
+func (v *VMacro) isRef() bool {
+	return false
+}
+
+func (v *VMacro) isString() bool {
+	return false
+}
+
+func (v *VMacro) isFunction() bool {
+	return false
+}
+
+func (v *VMacro) isTrue() bool {
+	return true
+}
+
+func (v *VMacro) isNil() bool {
+	return false
+}
+
+func (v *VMacro) isEqual(vv Value) bool {
+	return v == vv    // pointer equality
+}
+
+func (v *VMacro) typ() string {
+	return "macro"
+}
+
+func (v *VMacro) getValue() Value {
+	panic(fmt.Sprintf("unchecked access to %s", v.str()))
+}
+
+func (v *VMacro) setValue(cv Value) {
+	panic(fmt.Sprintf("unchecked access to %s", v.str()))
+}
+
+func (v *VMacro) isArray() bool {
+	return false
+}
+
+func (v *VMacro) getArray() []Value {
+	panic(fmt.Sprintf("unchecked access to %s", v.str()))
+}
+
+func (v *VMacro) isDict() bool {
+	return false
+}
+
+func (v *VMacro) getDict() map[*VSymbol]Value {
+	panic(fmt.Sprintf("unchecked access to %s", v.str()))
+}
+
+func (v *VString) display() string {
+	return escapeString(v.val)
+}
+
+// the inverse of Reader.readString
+
+func escapeString(s string) string {
+	var result strings.Builder
+	result.WriteByte('"')
+	for _, c := range s {
+		switch {
+		case c == '"' || c == '\\':
+			result.WriteByte('\\')
+			result.WriteRune(c)
+		case c == '\n':
+			result.WriteString("\\n")
+		case c == '\t':
+			result.WriteString("\\t")
+		case c == '\r':
+			result.WriteString("\\r")
+		case unicode.IsControl(c):
+			result.WriteString(fmt.Sprintf("\\u{%x}", c))
+		default:
+			result.WriteRune(c)
+		}
+	}
+	result.WriteByte('"')
+	return result.String()
+}
+
+func (v *VString) displayCDR() string {
+	panic(fmt.Sprintf("unchecked access to %s", v.str()))
+}
+
+func (v *VString) intValue() int {
+	panic(fmt.Sprintf("unchecked access to %s", v.str()))
+}
+
+func (v *VString) strValue() string {
+	return v.val
+}
+
+func (v *VString) boolValue() bool {
+	panic(fmt.Sprintf("unchecked access to %s", v.str()))
+}
+
+func (v *VString) apply(args []Value) (Value, error) {
+	return nil, newError(ERR_TYPE, v, "Value %s not applicable", v.str())
+}
+
+func (v *VString) str() string {
+	return fmt.Sprintf("VString[%s]", v.display())
+}
+
+func (v *VString) headValue() Value {
+	panic(fmt.Sprintf("unchecked access to %s", v.str()))
+}
+
+func (v *VString) tailValue() Value {
 	panic(fmt.Sprintf("unchecked access to %s", v.str()))
 }
 
@@ -937,591 +1509,1183 @@ func (v *VString) isAtom() bool {
 	return true
 }
 
-func (v *VString) isSymbol() bool {
+func (v *VString) isSymbol() bool {
+	return false
+}
+
+func (v *VString) isCons() bool {
+	return false
+}
+
+func (v *VString) isEmpty() bool {
+	return false
+}
+
+func (v *VString) isNumber() bool {
+	return false
+}
+
+func (v *VString) isBool() bool {
+	return false
+}
+
+func (v *VString) isRef() bool {
+	return false
+}
+
+func (v *VString) isString() bool {
+	return true
+}
+
+func (v *VString) isFunction() bool {
+	return false
+}
+
+func (v *VString) isTrue() bool {
+	return (v.val != "")
+}
+
+func (v *VString) isNil() bool {
+	return false
+}
+
+func (v *VString) isEqual(vv Value) bool {
+	return vv.isString() && v.strValue() == vv.strValue()
+}
+
+func (v *VString) typ() string {
+	return "string"
+}
+
+func (v *VString) getValue() Value {
+	panic(fmt.Sprintf("unchecked access to %s", v.str()))
+}
+
+func (v *VString) setValue(cv Value) {
+	panic(fmt.Sprintf("unchecked access to %s", v.str()))
+}
+
+func (v *VString) isArray() bool {
+	return false
+}
+
+func (v *VString) getArray() []Value {
+	panic(fmt.Sprintf("unchecked access to %s", v.str()))
+}
+
+func (v *VString) isDict() bool {
+	return false
+}
+
+func (v *VString) getDict() map[*VSymbol]Value {
+	panic(fmt.Sprintf("unchecked access to %s", v.str()))
+}
+
+func (v *VNil) display() string {
+	// figure out if this is the right thing?
+	return "#nil"
+}
+
+func (v *VNil) displayCDR() string {
+	panic(fmt.Sprintf("unchecked access to %s", v.str()))
+}
+
+func (v *VNil) intValue() int {
+	panic(fmt.Sprintf("unchecked access to %s", v.str()))
+}
+
+func (v *VNil) strValue() string {
+	panic(fmt.Sprintf("unchecked access to %s", v.str()))
+}
+
+func (v *VNil) boolValue() bool {
+	panic(fmt.Sprintf("unchecked access to %s", v.str()))
+}
+
+func (v *VNil) apply(args []Value) (Value, error) {
+	return nil, newError(ERR_TYPE, v, "Value %s not applicable", v.str())
+}
+
+func (v *VNil) str() string {
+	return fmt.Sprintf("VNil")
+}
+
+func (v *VNil) headValue() Value {
+	panic(fmt.Sprintf("unchecked access to %s", v.str()))
+}
+
+func (v *VNil) tailValue() Value {
+	panic(fmt.Sprintf("unchecked access to %s", v.str()))
+}
+
+func (v *VNil) isAtom() bool {
+	return false
+}
+
+func (v *VNil) isSymbol() bool {
+	return false
+}
+
+func (v *VNil) isCons() bool {
+	return false
+}
+
+func (v *VNil) isEmpty() bool {
+	return false
+}
+
+func (v *VNil) isNumber() bool {
+	return false
+}
+
+func (v *VNil) isBool() bool {
+	return false
+}
+
+func (v *VNil) isRef() bool {
+	return false
+}
+
+func (v *VNil) isString() bool {
+	return false
+}
+
+func (v *VNil) isFunction() bool {
+	return false
+}
+
+func (v *VNil) isTrue() bool {
+	return false
+}
+
+func (v *VNil) isNil() bool {
+	return true
+}
+
+func (v *VNil) isEqual(vv Value) bool {
+	return vv.isNil()
+}
+
+func (v *VNil) typ() string {
+	return "nil"
+}
+
+func (v *VNil) getValue() Value {
+	panic(fmt.Sprintf("unchecked access to %s", v.str()))
+}
+
+func (v *VNil) setValue(cv Value) {
+	panic(fmt.Sprintf("unchecked access to %s", v.str()))
+}
+
+func (v *VNil) isArray() bool {
+	return false
+}
+
+func (v *VNil) getArray() []Value {
+	panic(fmt.Sprintf("unchecked access to %s", v.str()))
+}
+
+func (v *VNil) isDict() bool {
+	return false
+}
+
+func (v *VNil) getDict() map[*VSymbol]Value {
+	panic(fmt.Sprintf("unchecked access to %s", v.str()))
+}
+
+func (v *VReference) display() string {
+	return fmt.Sprintf("#<ref %s>", v.content.display())
+}
+
+func (v *VReference) displayCDR() string {
+	panic(fmt.Sprintf("unchecked access to %s", v.str()))
+}
+
+func (v *VReference) intValue() int {
+	panic(fmt.Sprintf("unchecked access to %s", v.str()))
+}
+
+func (v *VReference) strValue() string {
+	panic(fmt.Sprintf("unchecked access to %s", v.str()))
+}
+
+func (v *VReference) boolValue() bool {
+	panic(fmt.Sprintf("unchecked access to %s", v.str()))
+}
+
+func (v *VReference) apply(args []Value) (Value, error) {
+	if len(args) > 1 {
+		return nil, newError(ERR_ARITY, v, "too many arguments %d to ref update", len(args))
+	}
+	if len(args) == 1 {
+		v.content = args[0]
+		return &VNil{}, nil
+	}
+	return v.content, nil
+}
+
+func (v *VReference) str() string {
+	return fmt.Sprintf("VReference[%s]", v.content.str())
+}
+
+func (v *VReference) headValue() Value {
+	panic(fmt.Sprintf("unchecked access to %s", v.str()))
+}
+
+func (v *VReference) tailValue() Value {
+	panic(fmt.Sprintf("unchecked access to %s", v.str()))
+}
+
+func (v *VReference) isAtom() bool {
+	return false   // ?
+}
+
+func (v *VReference) isSymbol() bool {
+	return false
+}
+
+func (v *VReference) isCons() bool {
+	return false
+}
+
+func (v *VReference) isEmpty() bool {
+	return false
+}
+
+func (v *VReference) isNumber() bool {
+	return false
+}
+
+func (v *VReference) isBool() bool {
+	return false
+}
+
+func (v *VReference) isRef() bool {
+	return true
+}
+
+func (v *VReference) isString() bool {
+	return false
+}
+
+func (v *VReference) isFunction() bool {
+	return false
+}
+
+func (v *VReference) isTrue() bool {
+	return false
+}
+
+func (v *VReference) isNil() bool {
+	return false
+}
+
+func (v *VReference) isEqual(vv Value) bool {
+	return v == vv     // pointer equality
+}
+
+func (v *VReference) typ() string {
+	return "reference"
+}
+
+func (v *VReference) getValue() Value {
+	return v.content
+}
+
+func (v *VReference) setValue(cv Value) {
+	v.content = cv
+}
+
+func (v *VReference) isArray() bool {
+	return false
+}
+
+func (v *VReference) getArray() []Value {
+	panic(fmt.Sprintf("unchecked access to %s", v.str()))
+}
+
+func (v *VReference) isDict() bool {
+	return false
+}
+
+func (v *VReference) getDict() map[*VSymbol]Value {
+	panic(fmt.Sprintf("unchecked access to %s", v.str()))
+}
+
+func (v *VArray) display() string {
+	s := make([]string, len(v.content))
+	for i, vv := range v.content {
+		s[i] = vv.display()
+	}
+	return fmt.Sprintf("#[%s]", strings.Join(s, " "))
+}
+
+func (v *VArray) displayCDR() string {
+	panic(fmt.Sprintf("unchecked access to %s", v.str()))
+}
+
+func (v *VArray) intValue() int {
+	panic(fmt.Sprintf("unchecked access to %s", v.str()))
+}
+
+func (v *VArray) strValue() string {
+	panic(fmt.Sprintf("unchecked access to %s", v.str()))
+}
+
+func (v *VArray) boolValue() bool {
+	panic(fmt.Sprintf("unchecked access to %s", v.str()))
+}
+
+func (v *VArray) apply(args []Value) (Value, error) {
+	if len(args) < 1 || !isInt(args[0]) {
+		return nil, newError(ERR_TYPE, v, "array indexing requires an index")
+	}
+	if len(args) > 2 {
+		return nil, newError(ERR_ARITY, v, "too many arguments %d to array update", len(args))
+	}
+	idx := args[0].intValue()
+	if idx < 0 || idx >= len(v.content) {
+		return nil, newError(ERR_INDEX, args[0], "array index out of bounds %d", idx)
+	}
+	if len(args) == 2 {
+		v.content[idx] = args[1]
+		return &VNil{}, nil
+	}
+	return v.content[idx], nil
+}
+
+func (v *VArray) str() string {
+	s := make([]string, len(v.content))
+	for i, vv := range v.content {
+		s[i] = vv.str()
+	}
+	return fmt.Sprintf("VArray[%s]", strings.Join(s, " "))
+}
+
+func (v *VArray) headValue() Value {
+	panic(fmt.Sprintf("unchecked access to %s", v.str()))
+}
+
+func (v *VArray) tailValue() Value {
+	panic(fmt.Sprintf("unchecked access to %s", v.str()))
+}
+
+func (v *VArray) isAtom() bool {
+	return false   // ?
+}
+
+func (v *VArray) isSymbol() bool {
+	return false
+}
+
+func (v *VArray) isCons() bool {
+	return false
+}
+
+func (v *VArray) isEmpty() bool {
+	return false
+}
+
+func (v *VArray) isNumber() bool {
+	return false
+}
+
+func (v *VArray) isBool() bool {
+	return false
+}
+
+func (v *VArray) isRef() bool {
+	return false
+}
+
+func (v *VArray) isString() bool {
+	return false
+}
+
+func (v *VArray) isFunction() bool {
+	return false
+}
+
+func (v *VArray) isTrue() bool {
+	return false
+}
+
+func (v *VArray) isNil() bool {
+	return false
+}
+
+func (v *VArray) isEqual(vv Value) bool {
+	return v == vv    // pointer equality because arrays will be mutable
+	/*
+	if !vv.isArray() || len(v.content) != len(vv.getArray()) {
+		return false}
+	vvcontent := vv.getArray()
+	for i := range(v.content) {
+		if !v.content[i].isEqual(vvcontent[i]) {
+			return false
+		}
+	}
+	return true
+	*/
+}
+
+func (v *VArray) typ() string {
+	return "array"
+}
+
+func (v *VArray) getValue() Value {
+	panic(fmt.Sprintf("unchecked access to %s", v.str()))
+}
+
+func (v *VArray) setValue(cv Value) {
+	panic(fmt.Sprintf("unchecked access to %s", v.str()))
+}
+
+func (v *VArray) isArray() bool {
+	return true
+}
+
+func (v *VArray) getArray() []Value {
+	return v.content
+}
+
+func (v *VArray) isDict() bool {
+	return false
+}
+
+func (v *VArray) getDict() map[*VSymbol]Value {
+	panic(fmt.Sprintf("unchecked access to %s", v.str()))
+}
+
+func (v *VDict) display() string {
+	keys := sortedKeys(v.content)
+	s := make([]string, len(keys))
+	for i, k := range keys {
+		s[i] = fmt.Sprintf("(%s %s)", k.name, v.content[k].display())
+	}
+	return fmt.Sprintf("#dict(%s)", strings.Join(s, " "))
+}
+
+func sortedKeys(content map[*VSymbol]Value) []*VSymbol {
+	keys := make([]*VSymbol, 0, len(content))
+	for k := range content {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].name < keys[j].name })
+	return keys
+}
+
+func (v *VDict) displayCDR() string {
+	panic(fmt.Sprintf("unchecked access to %s", v.str()))
+}
+
+func (v *VDict) intValue() int {
+	panic(fmt.Sprintf("unchecked access to %s", v.str()))
+}
+
+func (v *VDict) strValue() string {
+	panic(fmt.Sprintf("unchecked access to %s", v.str()))
+}
+
+func (v *VDict) boolValue() bool {
+	panic(fmt.Sprintf("unchecked access to %s", v.str()))
+}
+
+func (v *VDict) apply(args []Value) (Value, error) {
+	if len(args) < 1 || !args[0].isSymbol() {
+		return nil, newError(ERR_TYPE, v, "dict indexing requires a key")
+	}
+	if len(args) > 2 {
+		return nil, newError(ERR_ARITY, v, "too many arguments %d to dict update", len(args))
+	}
+	key := args[0].(*VSymbol)
+	if len(args) == 2 {
+		v.content[key] = args[1]
+		return &VNil{}, nil
+	}
+	result, ok := v.content[key]
+	if !ok {
+		return nil, newError(ERR_INDEX, args[0], "key %s not in dict", key.name)
+	}
+	return result, nil
+}
+
+func (v *VDict) str() string {
+	s := make([]string, len(v.content))
+	i := 0
+	for k, vv := range v.content {
+		s[i] = fmt.Sprintf("[%s %s]", k, vv.str())
+		i++
+	}
+	return fmt.Sprintf("VDict[%s]", strings.Join(s, " "))
+}
+
+func (v *VDict) headValue() Value {
+	panic(fmt.Sprintf("unchecked access to %s", v.str()))
+}
+
+func (v *VDict) tailValue() Value {
+	panic(fmt.Sprintf("unchecked access to %s", v.str()))
+}
+
+func (v *VDict) isAtom() bool {
+	return false   // ?
+}
+
+func (v *VDict) isSymbol() bool {
+	return false
+}
+
+func (v *VDict) isCons() bool {
+	return false
+}
+
+func (v *VDict) isEmpty() bool {
+	return false
+}
+
+func (v *VDict) isNumber() bool {
+	return false
+}
+
+func (v *VDict) isBool() bool {
+	return false
+}
+
+func (v *VDict) isRef() bool {
+	return false
+}
+
+func (v *VDict) isString() bool {
+	return false
+}
+
+func (v *VDict) isFunction() bool {
+	return false
+}
+
+func (v *VDict) isTrue() bool {
+	return false
+}
+
+func (v *VDict) isNil() bool {
+	return false
+}
+
+func (v *VDict) isEqual(vv Value) bool {
+	return v == vv    // pointer equality due to mutability
+}
+
+func (v *VDict) typ() string {
+	return "dict"
+}
+
+func (v *VDict) getValue() Value {
+	panic(fmt.Sprintf("unchecked access to %s", v.str()))
+}
+
+func (v *VDict) setValue(cv Value) {
+	panic(fmt.Sprintf("unchecked access to %s", v.str()))
+}
+
+func (v *VDict) isArray() bool {
+	return false
+}
+
+func (v *VDict) getArray() []Value {
+	panic(fmt.Sprintf("unchecked access to %s", v.str()))
+}
+
+func (v *VDict) isDict() bool {
+	return true
+}
+
+func (v *VDict) getDict() map[*VSymbol]Value {
+	return v.content
+}
+
+func (v *VError) display() string {
+	return fmt.Sprintf("#<error %s: %s>", v.err.kind, v.err.msg)
+}
+
+func (v *VError) displayCDR() string {
+	panic(fmt.Sprintf("unchecked access to %s", v.str()))
+}
+
+func (v *VError) intValue() int {
+	panic(fmt.Sprintf("unchecked access to %s", v.str()))
+}
+
+func (v *VError) strValue() string {
+	panic(fmt.Sprintf("unchecked access to %s", v.str()))
+}
+
+func (v *VError) boolValue() bool {
+	panic(fmt.Sprintf("unchecked access to %s", v.str()))
+}
+
+func (v *VError) apply(args []Value) (Value, error) {
+	return nil, newError(ERR_TYPE, v, "Value %s not applicable", v.str())
+}
+
+func (v *VError) str() string {
+	return fmt.Sprintf("VError[%s %s]", v.err.kind, v.err.msg)
+}
+
+func (v *VError) headValue() Value {
+	panic(fmt.Sprintf("unchecked access to %s", v.str()))
+}
+
+func (v *VError) tailValue() Value {
+	panic(fmt.Sprintf("unchecked access to %s", v.str()))
+}
+
+func (v *VError) isAtom() bool {
+	return false
+}
+
+func (v *VError) isSymbol() bool {
 	return false
 }
 
-func (v *VString) isCons() bool {
+func (v *VError) isCons() bool {
 	return false
 }
 
-func (v *VString) isEmpty() bool {
+func (v *VError) isEmpty() bool {
 	return false
 }
 
-func (v *VString) isNumber() bool {
+func (v *VError) isNumber() bool {
 	return false
 }
 
-func (v *VString) isBool() bool {
+func (v *VError) isBool() bool {
 	return false
 }
 
-func (v *VString) isRef() bool {
+func (v *VError) isRef() bool {
 	return false
 }
 
-func (v *VString) isString() bool {
-	return true
+func (v *VError) isString() bool {
+	return false
 }
 
-func (v *VString) isFunction() bool {
+func (v *VError) isFunction() bool {
 	return false
 }
 
-func (v *VString) isTrue() bool {
-	return (v.val != "")
+func (v *VError) isTrue() bool {
+	return true
 }
 
-func (v *VString) isNil() bool {
+func (v *VError) isNil() bool {
 	return false
 }
 
-func (v *VString) isEqual(vv Value) bool {
-	return vv.isString() && v.strValue() == vv.strValue()
+func (v *VError) isEqual(vv Value) bool {
+	return v == vv    // pointer equality
 }
 
-func (v *VString) typ() string {
-	return "string"
+func (v *VError) typ() string {
+	return "error"
 }
 
-func (v *VString) getValue() Value {
+func (v *VError) getValue() Value {
 	panic(fmt.Sprintf("unchecked access to %s", v.str()))
 }
 
-func (v *VString) setValue(cv Value) {
+func (v *VError) setValue(cv Value) {
 	panic(fmt.Sprintf("unchecked access to %s", v.str()))
 }
 
-func (v *VString) isArray() bool {
+func (v *VError) isArray() bool {
 	return false
 }
 
-func (v *VString) getArray() []Value {
+func (v *VError) getArray() []Value {
 	panic(fmt.Sprintf("unchecked access to %s", v.str()))
 }
 
-func (v *VString) isDict() bool {
+func (v *VError) isDict() bool {
 	return false
 }
 
-func (v *VString) getDict() map[string]Value {
+func (v *VError) getDict() map[*VSymbol]Value {
 	panic(fmt.Sprintf("unchecked access to %s", v.str()))
 }
 
-func (v *VNil) display() string {
-	// figure out if this is the right thing?
-	return "#nil"
+func (v *VRecord) display() string {
+	items := []string{v.variant.name}
+	for i, field := range v.variant.fields {
+		items = append(items, field + "=" + v.fields[i].display())
+	}
+	return fmt.Sprintf("#<%s>", strings.Join(items, " "))
 }
 
-func (v *VNil) displayCDR() string {
+func (v *VRecord) displayCDR() string {
 	panic(fmt.Sprintf("unchecked access to %s", v.str()))
 }
 
-func (v *VNil) intValue() int {
+func (v *VRecord) intValue() int {
 	panic(fmt.Sprintf("unchecked access to %s", v.str()))
 }
 
-func (v *VNil) strValue() string {
+func (v *VRecord) strValue() string {
 	panic(fmt.Sprintf("unchecked access to %s", v.str()))
 }
 
-func (v *VNil) boolValue() bool {
+func (v *VRecord) boolValue() bool {
 	panic(fmt.Sprintf("unchecked access to %s", v.str()))
 }
 
-func (v *VNil) apply(args []Value) (Value, error) {
-	return nil, fmt.Errorf("Value %s not applicable", v.str())
+func (v *VRecord) apply(args []Value) (Value, error) {
+	return nil, newError(ERR_TYPE, v, "Value %s not applicable", v.str())
 }
 
-func (v *VNil) str() string {
-	return fmt.Sprintf("VNil")
+func (v *VRecord) str() string {
+	items := []string{v.variant.name}
+	for _, field := range v.fields {
+		items = append(items, field.str())
+	}
+	return fmt.Sprintf("VRecord[%s]", strings.Join(items, " "))
 }
 
-func (v *VNil) headValue() Value {
+func (v *VRecord) headValue() Value {
 	panic(fmt.Sprintf("unchecked access to %s", v.str()))
 }
 
-func (v *VNil) tailValue() Value {
+func (v *VRecord) tailValue() Value {
 	panic(fmt.Sprintf("unchecked access to %s", v.str()))
 }
 
-func (v *VNil) isAtom() bool {
+func (v *VRecord) isAtom() bool {
 	return false
 }
 
-func (v *VNil) isSymbol() bool {
+func (v *VRecord) isSymbol() bool {
 	return false
 }
 
-func (v *VNil) isCons() bool {
+func (v *VRecord) isCons() bool {
 	return false
 }
 
-func (v *VNil) isEmpty() bool {
+func (v *VRecord) isEmpty() bool {
 	return false
 }
 
-func (v *VNil) isNumber() bool {
+func (v *VRecord) isNumber() bool {
 	return false
 }
 
-func (v *VNil) isBool() bool {
+func (v *VRecord) isBool() bool {
 	return false
 }
 
-func (v *VNil) isRef() bool {
+func (v *VRecord) isRef() bool {
 	return false
 }
 
-func (v *VNil) isString() bool {
+func (v *VRecord) isString() bool {
 	return false
 }
 
-func (v *VNil) isFunction() bool {
+func (v *VRecord) isFunction() bool {
 	return false
 }
 
-func (v *VNil) isTrue() bool {
-	return false
+func (v *VRecord) isTrue() bool {
+	return true
 }
 
-func (v *VNil) isNil() bool {
-	return true
+func (v *VRecord) isNil() bool {
+	return false
 }
 
-func (v *VNil) isEqual(vv Value) bool {
-	return vv.isNil()
+func (v *VRecord) isEqual(vv Value) bool {
+	other, ok := vv.(*VRecord)
+	if !ok || other.variant != v.variant {
+		return false
+	}
+	for i, field := range v.fields {
+		if !field.isEqual(other.fields[i]) {
+			return false
+		}
+	}
+	return true
 }
 
-func (v *VNil) typ() string {
-	return "nil"
+func (v *VRecord) typ() string {
+	return v.variant.typ.name
 }
 
-func (v *VNil) getValue() Value {
+func (v *VRecord) getValue() Value {
 	panic(fmt.Sprintf("unchecked access to %s", v.str()))
 }
 
-func (v *VNil) setValue(cv Value) {
+func (v *VRecord) setValue(cv Value) {
 	panic(fmt.Sprintf("unchecked access to %s", v.str()))
 }
 
-func (v *VNil) isArray() bool {
+func (v *VRecord) isArray() bool {
 	return false
 }
 
-func (v *VNil) getArray() []Value {
+func (v *VRecord) getArray() []Value {
 	panic(fmt.Sprintf("unchecked access to %s", v.str()))
 }
 
-func (v *VNil) isDict() bool {
+func (v *VRecord) isDict() bool {
 	return false
 }
 
-func (v *VNil) getDict() map[string]Value {
+func (v *VRecord) getDict() map[*VSymbol]Value {
 	panic(fmt.Sprintf("unchecked access to %s", v.str()))
 }
 
-func (v *VReference) display() string {
-	return fmt.Sprintf("#<ref %s>", v.content.display())
+func (v *VConstructor) display() string {
+	return fmt.Sprintf("#<constructor %s>", v.variant.name)
 }
 
-func (v *VReference) displayCDR() string {
+func (v *VConstructor) displayCDR() string {
 	panic(fmt.Sprintf("unchecked access to %s", v.str()))
 }
 
-func (v *VReference) intValue() int {
+func (v *VConstructor) intValue() int {
 	panic(fmt.Sprintf("unchecked access to %s", v.str()))
 }
 
-func (v *VReference) strValue() string {
+func (v *VConstructor) strValue() string {
 	panic(fmt.Sprintf("unchecked access to %s", v.str()))
 }
 
-func (v *VReference) boolValue() bool {
+func (v *VConstructor) boolValue() bool {
 	panic(fmt.Sprintf("unchecked access to %s", v.str()))
 }
 
-func (v *VReference) apply(args []Value) (Value, error) {
-	if len(args) > 1 {
-		return nil, fmt.Errorf("too many arguments %d to ref update", len(args))
-	}
-	if len(args) == 1 {
-		v.content = args[0]
-		return &VNil{}, nil
-	}
-	return v.content, nil
+func (v *VConstructor) apply(args []Value) (Value, error) {
+	return v.variant.construct(args)
 }
 
-func (v *VReference) str() string {
-	return fmt.Sprintf("VReference[%s]", v.content.str())
+func (v *VConstructor) str() string {
+	return fmt.Sprintf("VConstructor[%s]", v.variant.name)
 }
 
-func (v *VReference) headValue() Value {
+func (v *VConstructor) headValue() Value {
 	panic(fmt.Sprintf("unchecked access to %s", v.str()))
 }
 
-func (v *VReference) tailValue() Value {
+func (v *VConstructor) tailValue() Value {
 	panic(fmt.Sprintf("unchecked access to %s", v.str()))
 }
 
-func (v *VReference) isAtom() bool {
-	return false   // ?
+func (v *VConstructor) isAtom() bool {
+	return false
 }
 
-func (v *VReference) isSymbol() bool {
+func (v *VConstructor) isSymbol() bool {
 	return false
 }
 
-func (v *VReference) isCons() bool {
+func (v *VConstructor) isCons() bool {
 	return false
 }
 
-func (v *VReference) isEmpty() bool {
+func (v *VConstructor) isEmpty() bool {
 	return false
 }
 
-func (v *VReference) isNumber() bool {
+func (v *VConstructor) isNumber() bool {
 	return false
 }
 
-func (v *VReference) isBool() bool {
+func (v *VConstructor) isBool() bool {
 	return false
 }
 
-func (v *VReference) isRef() bool {
-	return true
+func (v *VConstructor) isRef() bool {
+	return false
 }
 
-func (v *VReference) isString() bool {
+func (v *VConstructor) isString() bool {
 	return false
 }
 
-func (v *VReference) isFunction() bool {
-	return false
+func (v *VConstructor) isFunction() bool {
+	return true
 }
 
-func (v *VReference) isTrue() bool {
-	return false
+func (v *VConstructor) isTrue() bool {
+	return true
 }
 
-func (v *VReference) isNil() bool {
+func (v *VConstructor) isNil() bool {
 	return false
 }
 
-func (v *VReference) isEqual(vv Value) bool {
-	return v == vv     // pointer equality
+func (v *VConstructor) isEqual(vv Value) bool {
+	return v == vv    // pointer equality
 }
 
-func (v *VReference) typ() string {
-	return "reference"
+func (v *VConstructor) typ() string {
+	return "fun"
 }
 
-func (v *VReference) getValue() Value {
-	return v.content
+func (v *VConstructor) getValue() Value {
+	panic(fmt.Sprintf("unchecked access to %s", v.str()))
 }
 
-func (v *VReference) setValue(cv Value) {
-	v.content = cv
+func (v *VConstructor) setValue(cv Value) {
+	panic(fmt.Sprintf("unchecked access to %s", v.str()))
 }
 
-func (v *VReference) isArray() bool {
+func (v *VConstructor) isArray() bool {
 	return false
 }
 
-func (v *VReference) getArray() []Value {
+func (v *VConstructor) getArray() []Value {
 	panic(fmt.Sprintf("unchecked access to %s", v.str()))
 }
 
-func (v *VReference) isDict() bool {
+func (v *VConstructor) isDict() bool {
 	return false
 }
 
-func (v *VReference) getDict() map[string]Value {
+func (v *VConstructor) getDict() map[*VSymbol]Value {
 	panic(fmt.Sprintf("unchecked access to %s", v.str()))
 }
 
-func (v *VArray) display() string {
-	s := make([]string, len(v.content))
-	for i, vv := range v.content {
-		s[i] = vv.display()
+func (v *VObject) display() string {
+	items := []string{"object", v.class.name}
+	for _, field := range v.class.fields {
+		items = append(items, field + "=" + v.fields[field].getValue().display())
 	}
-	return fmt.Sprintf("#[%s]", strings.Join(s, " "))
+	return fmt.Sprintf("#<%s>", strings.Join(items, " "))
 }
 
-func (v *VArray) displayCDR() string {
+func (v *VObject) displayCDR() string {
 	panic(fmt.Sprintf("unchecked access to %s", v.str()))
 }
 
-func (v *VArray) intValue() int {
+func (v *VObject) intValue() int {
 	panic(fmt.Sprintf("unchecked access to %s", v.str()))
 }
 
-func (v *VArray) strValue() string {
+func (v *VObject) strValue() string {
 	panic(fmt.Sprintf("unchecked access to %s", v.str()))
 }
 
-func (v *VArray) boolValue() bool {
+func (v *VObject) boolValue() bool {
 	panic(fmt.Sprintf("unchecked access to %s", v.str()))
 }
 
-func (v *VArray) apply(args []Value) (Value, error) {
-	if len(args) < 1 || !args[0].isNumber() {
-		return nil, fmt.Errorf("array indexing requires an index")
-	}
-	if len(args) > 2 {
-		return nil, fmt.Errorf("too many arguments %d to array update", len(args))
-	}
-	idx := args[0].intValue()
-	if idx < 0 || idx >= len(v.content) {
-		return nil, fmt.Errorf("array index out of bounds %d", idx)
-	}
-	if len(args) == 2 {
-		v.content[idx] = args[1]
-		return &VNil{}, nil
-	}
-	return v.content[idx], nil
+func (v *VObject) apply(args []Value) (Value, error) {
+	return nil, newError(ERR_TYPE, v, "Value %s not applicable", v.str())
 }
 
-func (v *VArray) str() string {
-	s := make([]string, len(v.content))
-	for i, vv := range v.content {
-		s[i] = vv.str()
-	}
-	return fmt.Sprintf("VArray[%s]", strings.Join(s, " "))
+func (v *VObject) str() string {
+	return fmt.Sprintf("VObject[%s]", v.class.name)
 }
 
-func (v *VArray) headValue() Value {
+func (v *VObject) headValue() Value {
 	panic(fmt.Sprintf("unchecked access to %s", v.str()))
 }
 
-func (v *VArray) tailValue() Value {
+func (v *VObject) tailValue() Value {
 	panic(fmt.Sprintf("unchecked access to %s", v.str()))
 }
 
-func (v *VArray) isAtom() bool {
-	return false   // ?
+func (v *VObject) isAtom() bool {
+	return false
 }
 
-func (v *VArray) isSymbol() bool {
+func (v *VObject) isSymbol() bool {
 	return false
 }
 
-func (v *VArray) isCons() bool {
+func (v *VObject) isCons() bool {
 	return false
 }
 
-func (v *VArray) isEmpty() bool {
+func (v *VObject) isEmpty() bool {
 	return false
 }
 
-func (v *VArray) isNumber() bool {
+func (v *VObject) isNumber() bool {
 	return false
 }
 
-func (v *VArray) isBool() bool {
+func (v *VObject) isBool() bool {
 	return false
 }
 
-func (v *VArray) isRef() bool {
+func (v *VObject) isRef() bool {
 	return false
 }
 
-func (v *VArray) isString() bool {
+func (v *VObject) isString() bool {
 	return false
 }
 
-func (v *VArray) isFunction() bool {
+func (v *VObject) isFunction() bool {
 	return false
 }
 
-func (v *VArray) isTrue() bool {
-	return false
+func (v *VObject) isTrue() bool {
+	return true
 }
 
-func (v *VArray) isNil() bool {
+func (v *VObject) isNil() bool {
 	return false
 }
 
-func (v *VArray) isEqual(vv Value) bool {
-	return v == vv    // pointer equality because arrays will be mutable
-	/*
-	if !vv.isArray() || len(v.content) != len(vv.getArray()) {
-		return false}
-	vvcontent := vv.getArray()
-	for i := range(v.content) {
-		if !v.content[i].isEqual(vvcontent[i]) {
-			return false
-		}
-	}
-	return true
-	*/
+func (v *VObject) isEqual(vv Value) bool {
+	return v == vv    // pointer equality
 }
 
-func (v *VArray) typ() string {
-	return "array"
+func (v *VObject) typ() string {
+	return v.class.name
 }
 
-func (v *VArray) getValue() Value {
+func (v *VObject) getValue() Value {
 	panic(fmt.Sprintf("unchecked access to %s", v.str()))
 }
 
-func (v *VArray) setValue(cv Value) {
+func (v *VObject) setValue(cv Value) {
 	panic(fmt.Sprintf("unchecked access to %s", v.str()))
 }
 
-func (v *VArray) isArray() bool {
-	return true
+func (v *VObject) isArray() bool {
+	return false
 }
 
-func (v *VArray) getArray() []Value {
-	return v.content
+func (v *VObject) getArray() []Value {
+	panic(fmt.Sprintf("unchecked access to %s", v.str()))
 }
 
-func (v *VArray) isDict() bool {
+func (v *VObject) isDict() bool {
 	return false
 }
 
-func (v *VArray) getDict() map[string]Value {
+func (v *VObject) getDict() map[*VSymbol]Value {
 	panic(fmt.Sprintf("unchecked access to %s", v.str()))
 }
 
-func (v *VDict) display() string {
-	s := make([]string, len(v.content))
-	i := 0
-	for k, vv := range v.content {
-		s[i] = fmt.Sprintf("(%s %s)", k, vv.display())
-		i++
-	}
-	return fmt.Sprintf("#(%s)", strings.Join(s, " "))
+func (v *VGeneric) display() string {
+	return fmt.Sprintf("#<generic %s>", v.name)
 }
 
-func (v *VDict) displayCDR() string {
+func (v *VGeneric) displayCDR() string {
 	panic(fmt.Sprintf("unchecked access to %s", v.str()))
 }
 
-func (v *VDict) intValue() int {
+func (v *VGeneric) intValue() int {
 	panic(fmt.Sprintf("unchecked access to %s", v.str()))
 }
 
-func (v *VDict) strValue() string {
+func (v *VGeneric) strValue() string {
 	panic(fmt.Sprintf("unchecked access to %s", v.str()))
 }
 
-func (v *VDict) boolValue() bool {
+func (v *VGeneric) boolValue() bool {
 	panic(fmt.Sprintf("unchecked access to %s", v.str()))
 }
 
-func (v *VDict) apply(args []Value) (Value, error) {
-	if len(args) < 1 || !args[0].isSymbol() {
-		return nil, fmt.Errorf("dict indexing requires a key")
-	}
-	if len(args) > 2 {
-		return nil, fmt.Errorf("too many arguments %d to dict update", len(args))
-	}
-	key := args[0].strValue()
-	if len(args) == 2 {
-		v.content[key] = args[1]
-		return &VNil{}, nil
-	}
-	result, ok := v.content[key]
-	if !ok {
-		return nil, fmt.Errorf("key %s not in dict", key)
+func (v *VGeneric) apply(args []Value) (Value, error) {
+	fn, err := v.dispatch(args)
+	if err != nil {
+		return nil, err
 	}
-	return result, nil
+	return fn.apply(args)
 }
 
-func (v *VDict) str() string {
-	s := make([]string, len(v.content))
-	i := 0
-	for k, vv := range v.content {
-		s[i] = fmt.Sprintf("[%s %s]", k, vv.str())
-		i++
-	}
-	return fmt.Sprintf("VDict[%s]", strings.Join(s, " "))
+func (v *VGeneric) str() string {
+	return fmt.Sprintf("VGeneric[%s]", v.name)
 }
 
-func (v *VDict) headValue() Value {
+func (v *VGeneric) headValue() Value {
 	panic(fmt.Sprintf("unchecked access to %s", v.str()))
 }
 
-func (v *VDict) tailValue() Value {
+func (v *VGeneric) tailValue() Value {
 	panic(fmt.Sprintf("unchecked access to %s", v.str()))
 }
 
-func (v *VDict) isAtom() bool {
-	return false   // ?
+func (v *VGeneric) isAtom() bool {
+	return false
 }
 
-func (v *VDict) isSymbol() bool {
+func (v *VGeneric) isSymbol() bool {
 	return false
 }
 
-func (v *VDict) isCons() bool {
+func (v *VGeneric) isCons() bool {
 	return false
 }
 
-func (v *VDict) isEmpty() bool {
+func (v *VGeneric) isEmpty() bool {
 	return false
 }
 
-func (v *VDict) isNumber() bool {
+func (v *VGeneric) isNumber() bool {
 	return false
 }
 
-func (v *VDict) isBool() bool {
+func (v *VGeneric) isBool() bool {
 	return false
 }
 
-func (v *VDict) isRef() bool {
+func (v *VGeneric) isRef() bool {
 	return false
 }
 
-func (v *VDict) isString() bool {
+func (v *VGeneric) isString() bool {
 	return false
 }
 
-func (v *VDict) isFunction() bool {
-	return false
+func (v *VGeneric) isFunction() bool {
+	return true
 }
 
-func (v *VDict) isTrue() bool {
-	return false
+func (v *VGeneric) isTrue() bool {
+	return true
 }
 
-func (v *VDict) isNil() bool {
+func (v *VGeneric) isNil() bool {
 	return false
 }
 
-func (v *VDict) isEqual(vv Value) bool {
-	return v == vv    // pointer equality due to mutability
+func (v *VGeneric) isEqual(vv Value) bool {
+	return v == vv    // pointer equality
 }
 
-func (v *VDict) typ() string {
-	return "dict"
+func (v *VGeneric) typ() string {
+	return "fun"
 }
 
-func (v *VDict) getValue() Value {
+func (v *VGeneric) getValue() Value {
 	panic(fmt.Sprintf("unchecked access to %s", v.str()))
 }
 
-func (v *VDict) setValue(cv Value) {
+func (v *VGeneric) setValue(cv Value) {
 	panic(fmt.Sprintf("unchecked access to %s", v.str()))
 }
 
-func (v *VDict) isArray() bool {
+func (v *VGeneric) isArray() bool {
 	return false
 }
 
-func (v *VDict) getArray() []Value {
+func (v *VGeneric) getArray() []Value {
 	panic(fmt.Sprintf("unchecked access to %s", v.str()))
 }
 
-func (v *VDict) isDict() bool {
-	return true
+func (v *VGeneric) isDict() bool {
+	return false
 }
 
-func (v *VDict) getDict() map[string]Value {
-	return v.content
+func (v *VGeneric) getDict() map[*VSymbol]Value {
+	panic(fmt.Sprintf("unchecked access to %s", v.str()))
 }
-